@@ -10,12 +10,18 @@ import (
 	"time"
 
 	"github.com/MarkMiraclee/gophermart/internal/accrual"
+	"github.com/MarkMiraclee/gophermart/internal/auth"
 	"github.com/MarkMiraclee/gophermart/internal/config"
 	"github.com/MarkMiraclee/gophermart/internal/handlers"
+	"github.com/MarkMiraclee/gophermart/internal/logger"
 	"github.com/MarkMiraclee/gophermart/internal/storage"
+	"github.com/MarkMiraclee/gophermart/internal/webhook"
 	"github.com/sirupsen/logrus"
 )
 
+// shutdownTimeout bounds the whole teardown after SIGINT/SIGTERM.
+const shutdownTimeout = 5 * time.Second
+
 func main() {
 	log := logrus.New()
 	log.SetFormatter(&logrus.JSONFormatter{})
@@ -26,44 +32,151 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
+	if err := configureLogger(log, cfg); err != nil {
+		log.Fatalf("failed to configure logger: %v", err)
+	}
+	secrets := append(cfg.JWTSecrets(), cfg.WebhookSecret)
+	accrualHeaders, _ := cfg.AccrualRequestHeaders()
+	for _, values := range accrualHeaders {
+		secrets = append(secrets, values...)
+	}
+	log.AddHook(logger.NewSanitizer(secrets...))
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	db, err := storage.NewPostgresStorage(ctx, cfg.DatabaseURI, log)
+	db, err := storage.NewPostgresStorage(ctx, cfg.DatabaseURI, cfg.DatabaseReplicaURI, storage.PoolOptions{
+		MaxConnIdleTime:   cfg.DBMaxConnIdleTime,
+		HealthCheckPeriod: cfg.DBHealthCheckPeriod,
+	}, log)
 	if err != nil {
 		log.Fatalf("failed to initialize storage: %v", err)
 	}
-	defer db.Close()
 
-	accrualClient := accrual.NewClient(cfg.AccrualSystemAddress, db, log)
-	go accrualClient.Start(ctx)
+	hasher, err := auth.NewPasswordHasher(cfg.PasswordHasher, cfg.BcryptCost)
+	if err != nil {
+		log.Fatalf("failed to create password hasher: %v", err)
+	}
 
-	api := handlers.NewAPI(db, log, cfg.JWTSecret)
+	api := handlers.NewAPI(db, log, cfg, hasher)
 	router := handlers.NewRouter(api)
 
+	// The API drops cached balances of users whose orders get credited.
+	notifier := accrual.Notifiers{api}
+	if cfg.WebhookURL != "" {
+		webhookNotifier := webhook.NewNotifier(cfg.WebhookURL, cfg.WebhookSecret, log)
+		go webhookNotifier.Start(ctx)
+		notifier = append(notifier, webhookNotifier)
+	}
+
+	// accrualDone is closed once the poller has returned, including its last in-flight poll.
+	accrualDone := make(chan struct{})
+	if cfg.AccrualEnabled() {
+		accrualClient := accrual.NewClient(cfg, db, log, notifier)
+		go func() {
+			defer close(accrualDone)
+			accrualClient.Start(ctx)
+		}()
+	} else {
+		close(accrualDone)
+		log.Warn("accrual disabled: orders stay NEW until ACCRUAL_SYSTEM_ADDRESS is set and ACCRUAL_DISABLED is off")
+	}
+
 	server := &http.Server{
-		Addr:    cfg.RunAddress,
-		Handler: router,
+		Addr:         cfg.RunAddress,
+		Handler:      router,
+		ReadTimeout:  cfg.ServerReadTimeout,
+		WriteTimeout: cfg.ServerWriteTimeout,
+		IdleTimeout:  cfg.ServerIdleTimeout,
 	}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if cfg.TLSEnabled() {
+			err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("listen: %s\n", err)
 		}
 	}()
-	log.Infof("server started on %s", cfg.RunAddress)
+	log.Infof("server started on %s (tls: %t)", cfg.RunAddress, cfg.TLSEnabled())
 
-	<-ctx.Done()
+	// The operational listener serves metrics, admin routes and pprof, or only pprof when no
+	// INTERNAL_ADDRESS is set. Profiles can take longer than the API write timeout, so it sets none.
+	var internalServer *http.Server
+	switch {
+	case cfg.InternalAddress != "":
+		internalServer = newInternalServer(cfg, cfg.InternalAddress, handlers.NewInternalRouter(api))
+	case cfg.PprofEnabled:
+		internalServer = newInternalServer(cfg, cfg.PprofAddress, handlers.NewPprofRouter())
+	}
+	if cfg.InternalAddress == "" {
+		log.Warnf("INTERNAL_ADDRESS is not set: /metrics and admin routes are served on %s to administrators only", cfg.RunAddress)
+	}
+	if internalServer != nil {
+		go func() {
+			if err := internalServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Errorf("internal listen: %v", err)
+			}
+		}()
+		log.Infof("internal server started on %s (pprof: %t)", internalServer.Addr, cfg.PprofEnabled)
+	}
 
-	log.Info("shutting down server gracefully")
+	<-ctx.Done()
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Teardown runs in dependency order under one deadline: the poller and the HTTP server both
+	// write to the database, so it is closed only after both have stopped.
+	log.Info("shutting down gracefully")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
+	log.Info("shutdown: waiting for the accrual client to finish its current poll")
+	select {
+	case <-accrualDone:
+	case <-shutdownCtx.Done():
+		log.Warn("shutdown: accrual client did not stop in time")
+	}
+
+	log.Info("shutdown: stopping the HTTP server")
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Fatalf("server shutdown failed: %+v", err)
+		log.Errorf("server shutdown failed: %+v", err)
+	}
+
+	if internalServer != nil {
+		if err := internalServer.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("internal server shutdown failed: %+v", err)
+		}
 	}
 
+	log.Info("shutdown: closing the database pools")
+	db.Close()
+
 	log.Info("server exited properly")
 }
+
+func newInternalServer(cfg *config.Config, address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ServerReadTimeout,
+		IdleTimeout:       cfg.ServerIdleTimeout,
+	}
+}
+
+func configureLogger(log *logrus.Logger, cfg *config.Config) error {
+	level, err := logrus.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(level)
+
+	switch cfg.LogFormat {
+	case "text":
+		log.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		log.SetFormatter(&logrus.JSONFormatter{})
+	}
+	return nil
+}
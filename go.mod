@@ -11,6 +11,8 @@ require (
 	github.com/jackc/pgx/v5 v5.7.5
 	github.com/sirupsen/logrus v1.9.3
 	golang.org/x/crypto v0.39.0
+	golang.org/x/text v0.26.0
+	golang.org/x/time v0.6.0
 )
 
 require (
@@ -20,5 +22,4 @@ require (
 	golang.org/x/net v0.41.0 // indirect
 	golang.org/x/sync v0.15.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
-	golang.org/x/text v0.26.0 // indirect
 )
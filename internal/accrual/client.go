@@ -5,40 +5,151 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/MarkMiraclee/gophermart/internal/config"
+	"github.com/MarkMiraclee/gophermart/internal/logger"
+	"github.com/MarkMiraclee/gophermart/internal/metrics"
 	"github.com/MarkMiraclee/gophermart/internal/models"
 	"github.com/MarkMiraclee/gophermart/internal/storage"
 	"github.com/go-resty/resty/v2"
-	"github.com/sirupsen/logrus"
 )
 
 const (
-	updateInterval = 1 * time.Second
+	sweepInterval = 1 * time.Minute
+	lagInterval   = 15 * time.Second
+	// Orders polled at least slowdownAttempts times are only polled on every slowdownEvery-th tick.
+	slowdownAttempts = 60
+	slowdownEvery    = 10
+	retryCount       = 3
+	retryWaitTime    = 100 * time.Millisecond
+	retryMaxWaitTime = 2 * time.Second
 )
 
+// rateLimitAttempts bounds the requests for one order in one poll while the accrual system
+// answers 429; the order is retried on a later tick after that.
+const rateLimitAttempts = 3
+
+// pollIntervals is the minimum time between two polls of an order in each pending status.
+var pollIntervals = map[models.OrderStatus]time.Duration{
+	models.OrderStatusNew:        2 * time.Second,
+	models.OrderStatusProcessing: 5 * time.Second,
+}
+
+// accrualStatuses maps the statuses of the accrual system API onto order statuses; anything
+// else is never written. REGISTERED means the order is known but not calculated yet, which is
+// PROCESSING to the user, and keeps the order in the poll set.
+var accrualStatuses = map[string]models.OrderStatus{
+	"REGISTERED": models.OrderStatusProcessing,
+	"PROCESSING": models.OrderStatusProcessing,
+	"PROCESSED":  models.OrderStatusProcessed,
+	"INVALID":    models.OrderStatusInvalid,
+}
+
+// Notifier is informed about orders that reached the PROCESSED status.
+type Notifier interface {
+	Notify(order models.Order)
+}
+
+// Notifiers informs each of its notifiers in turn.
+type Notifiers []Notifier
+
+func (n Notifiers) Notify(order models.Order) {
+	for _, notifier := range n {
+		notifier.Notify(order)
+	}
+}
+
 type Client struct {
-	address string
-	storage storage.Storage
-	log     *logrus.Logger
-	client  *resty.Client
+	endpoints *endpoints
+	// orderPath is the fmt template of an order's path, with one %s for the order number.
+	orderPath string
+	storage   storage.Storage
+	log       logger.Logger
+	client    *resty.Client
+	notifier  Notifier
+	// pollInterval is how often due orders are looked up and polled.
+	pollInterval time.Duration
+	// maxOrderAge is how long an order may stay pending before it is marked INVALID; zero disables the sweep.
+	maxOrderAge time.Duration
+	concurrency *concurrencyController
+	// retryBackoff is the pause after a 429 without a usable Retry-After header.
+	retryBackoff time.Duration
+	pause        pause
+	ticks        int
 }
 
-func NewClient(address string, s storage.Storage, log *logrus.Logger) *Client {
+// NewClient creates an accrual poller; notifier may be nil.
+func NewClient(cfg *config.Config, s storage.Storage, log logger.Logger, notifier Notifier) *Client {
+	client := newRestyClient(cfg.AccrualHTTPTimeout).SetHeader("User-Agent", cfg.AccrualUserAgent)
+	// The config has been validated, so the headers parse.
+	headers, _ := cfg.AccrualRequestHeaders()
+	for name, values := range headers {
+		for _, value := range values {
+			client.Header.Add(name, value)
+		}
+	}
+
 	return &Client{
-		address: address,
-		storage: s,
-		log:     log,
-		client:  resty.New(),
+		endpoints: newEndpoints(cfg.AccrualAddresses()),
+		orderPath: cfg.AccrualOrderPath,
+		storage:   s,
+		log:       log,
+		client:    client,
+		notifier:  notifier,
+
+		pollInterval: cfg.AccrualPollInterval,
+		maxOrderAge:  cfg.AccrualMaxOrderAge,
+		concurrency:  newConcurrencyController(cfg.AccrualConcurrency),
+		retryBackoff: cfg.AccrualRetryBackoff,
 	}
 }
 
+// normalizeAddress defaults a missing scheme to http and drops trailing slashes.
+func normalizeAddress(address string) string {
+	address = strings.TrimSpace(address)
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return strings.TrimRight(address, "/")
+}
+
+// newRestyClient retries transient failures (transport errors and 5xx responses)
+// with resty's exponential backoff with jitter. 429 is handled by the caller via Retry-After.
+func newRestyClient(timeout time.Duration) *resty.Client {
+	return resty.New().
+		SetTimeout(timeout).
+		SetRetryCount(retryCount).
+		SetRetryWaitTime(retryWaitTime).
+		SetRetryMaxWaitTime(retryMaxWaitTime).
+		AddRetryCondition(func(resp *resty.Response, err error) bool {
+			if err != nil {
+				return true
+			}
+			return resp.StatusCode() >= http.StatusInternalServerError
+		})
+}
+
 func (c *Client) Start(ctx context.Context) {
-	ticker := time.NewTicker(updateInterval)
+	ticker := time.NewTicker(c.pollInterval)
 	defer ticker.Stop()
 
+	var sweep <-chan time.Time
+	if c.maxOrderAge > 0 {
+		sweepTicker := time.NewTicker(sweepInterval)
+		defer sweepTicker.Stop()
+		sweep = sweepTicker.C
+	}
+
+	lagTicker := time.NewTicker(lagInterval)
+	defer lagTicker.Stop()
+
 	c.log.Info("accrual client started")
+	c.recordLag(ctx)
 
 	for {
 		select {
@@ -47,47 +158,224 @@ func (c *Client) Start(ctx context.Context) {
 			return
 		case <-ticker.C:
 			c.processOrders(ctx)
+		case <-sweep:
+			c.invalidateStaleOrders(ctx)
+		case <-lagTicker.C:
+			c.recordLag(ctx)
 		}
 	}
 }
 
+// processOrders polls the accrual service for every pending order and writes
+// the collected results in a single batch once all requests have finished.
 func (c *Client) processOrders(ctx context.Context) {
-	orders, err := c.storage.GetOrdersByStatus(ctx, []string{"NEW", "PROCESSING"})
-	if err != nil {
-		c.log.Errorf("failed to get orders for processing: %v", err)
-		return
+	now := time.Now()
+	var orders []models.Order
+	for _, status := range models.PendingOrderStatuses {
+		due, err := c.storage.GetOrdersByStatus(ctx, []models.OrderStatus{status}, now.Add(-pollIntervals[status]))
+		if err != nil {
+			c.log.Errorf("failed to get %s orders for processing: %v", status, err)
+			return
+		}
+		orders = append(orders, due...)
 	}
 
+	c.ticks++
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		updates []models.OrderUpdate
+		pending []string
+	)
+	workers := make(chan struct{}, c.concurrency.Limit())
 	for _, order := range orders {
-		go c.updateOrderStatus(ctx, order.Number)
+		if order.Attempts >= slowdownAttempts && c.ticks%slowdownEvery != 0 {
+			continue
+		}
+
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(orderNumber string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			update := c.updateOrderStatus(ctx, orderNumber)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if update != nil {
+				updates = append(updates, *update)
+			}
+			if update == nil || !update.Status.IsTerminal() {
+				pending = append(pending, orderNumber)
+			}
+		}(order.Number)
+	}
+	wg.Wait()
+
+	// Results already fetched are written even when shutdown has begun meanwhile, so that the
+	// last poll is drained rather than lost.
+	writeCtx := context.WithoutCancel(ctx)
+	updated, err := c.storage.UpdateOrdersBatch(writeCtx, updates)
+	if err != nil {
+		c.log.Errorf("failed to update %d orders: %v", len(updates), err)
+	}
+	if err := c.storage.MarkOrdersPolled(writeCtx, pending); err != nil {
+		c.log.Errorf("failed to record poll attempts for %d orders: %v", len(pending), err)
+	}
+
+	for _, order := range updated {
+		if order.Status != models.OrderStatusProcessed {
+			continue
+		}
+		metrics.AccrualProcessingTime.Observe(order.UpdatedAt.Sub(order.UploadedAt))
+		if c.notifier != nil {
+			c.notifier.Notify(order)
+		}
+	}
+}
+
+// recordLag publishes how long the oldest pending order has been waiting for accrual.
+func (c *Client) recordLag(ctx context.Context) {
+	oldest, err := c.storage.GetOldestUploadedAt(ctx, models.PendingOrderStatuses)
+	if err != nil {
+		c.log.Errorf("failed to get the oldest pending order: %v", err)
+		return
+	}
+	age := 0.0
+	if oldest != nil {
+		age = time.Since(*oldest).Seconds()
 	}
+	metrics.AccrualOldestPendingAge.Set(age)
 }
 
-func (c *Client) updateOrderStatus(ctx context.Context, orderNumber string) {
-	url := fmt.Sprintf("%s/api/orders/%s", c.address, orderNumber)
-	resp, err := c.client.R().SetContext(ctx).Get(url)
+// invalidateStaleOrders gives up on orders that have been pending longer than maxOrderAge.
+func (c *Client) invalidateStaleOrders(ctx context.Context) {
+	count, err := c.storage.InvalidateStaleOrders(ctx, models.PendingOrderStatuses, time.Now().Add(-c.maxOrderAge))
 	if err != nil {
-		c.log.Errorf("failed to request accrual for order %s: %v", orderNumber, err)
+		c.log.Errorf("failed to invalidate stale orders: %v", err)
 		return
 	}
+	if count > 0 {
+		c.log.Warnf("marked %d orders pending longer than %s as INVALID", count, c.maxOrderAge)
+	}
+}
+
+func (c *Client) updateOrderStatus(ctx context.Context, orderNumber string) *models.OrderUpdate {
+	for attempt := 1; ; attempt++ {
+		if !c.pause.Wait(ctx) {
+			return nil
+		}
+		resp := c.get(ctx, orderNumber)
+		if resp == nil {
+			return nil
+		}
+		if resp.StatusCode() != http.StatusTooManyRequests {
+			return c.orderUpdate(orderNumber, resp)
+		}
+
+		if limit, changed := c.concurrency.RateLimited(); changed {
+			c.log.Warnf("accrual system is rate limiting, reducing concurrency to %d", limit)
+		}
+		delay := retryAfterDelay(resp.Header().Get("Retry-After"), time.Now(), c.retryBackoff)
+		c.log.Warnf("rate limit hit, pausing accrual requests for %s", delay)
+		c.pause.Extend(delay)
+		if attempt >= rateLimitAttempts {
+			c.log.Warnf("order %s still rate limited after %d attempts, retrying on a later poll", orderNumber, attempt)
+			return nil
+		}
+	}
+}
 
+// orderUpdate turns any accrual response but 429 into the update to store, or nil for none.
+func (c *Client) orderUpdate(orderNumber string, resp *resty.Response) *models.OrderUpdate {
 	switch resp.StatusCode() {
 	case http.StatusOK:
 		var accrualResp models.AccrualResponse
 		if err := json.Unmarshal(resp.Body(), &accrualResp); err != nil {
 			c.log.Errorf("failed to unmarshal accrual response for order %s: %v", orderNumber, err)
-			return
+			return nil
+		}
+		if accrualResp.Order != orderNumber {
+			// Trusting the echoed number would write this result to another order.
+			c.log.Warnf("ignoring accrual response for order %s that refers to order %q", orderNumber, accrualResp.Order)
+			return nil
+		}
+		status, ok := accrualStatuses[accrualResp.Status]
+		if !ok {
+			c.log.Warnf("ignoring unknown accrual status %q for order %s", accrualResp.Status, orderNumber)
+			return nil
 		}
-		if err := c.storage.UpdateOrder(ctx, accrualResp.Order, accrualResp.Status, accrualResp.Accrual); err != nil {
-			c.log.Errorf("failed to update order %s: %v", orderNumber, err)
+		return &models.OrderUpdate{
+			Number:  orderNumber,
+			Status:  status,
+			Accrual: accrualResp.Accrual,
 		}
 	case http.StatusNoContent:
-	case http.StatusTooManyRequests:
-		retryAfter := resp.Header().Get("Retry-After")
-		if seconds, err := strconv.Atoi(retryAfter); err == nil {
-			c.log.Warnf("rate limit hit, sleeping for %d seconds", seconds)
-			time.Sleep(time.Duration(seconds) * time.Second)
-			c.updateOrderStatus(ctx, orderNumber)
+		// The order is not registered in the accrual system yet; poll it again later.
+	case http.StatusBadRequest, http.StatusNotFound:
+		// The accrual system rejected the order number itself, so no reward will ever be calculated.
+		c.log.Warnf("accrual system rejected order %s with status %d, marking it INVALID", orderNumber, resp.StatusCode())
+		return &models.OrderUpdate{Number: orderNumber, Status: models.OrderStatusInvalid}
+	default:
+		if resp.StatusCode() >= http.StatusInternalServerError {
+			c.log.Errorf("accrual system failed for order %s with status %d, will retry", orderNumber, resp.StatusCode())
+		} else {
+			c.log.Warnf("unexpected accrual response for order %s: status %d", orderNumber, resp.StatusCode())
+		}
+	}
+	return nil
+}
+
+// get requests the order from the accrual system, failing over to the next address on
+// connection errors, and returns nil if no address answered.
+func (c *Client) get(ctx context.Context, orderNumber string) *resty.Response {
+	path := fmt.Sprintf(c.orderPath, url.PathEscape(orderNumber))
+	for range c.endpoints.Len() {
+		i, address := c.endpoints.Pick(time.Now())
+		orderURL, err := url.JoinPath(address, path)
+		if err != nil {
+			c.log.Errorf("failed to build accrual URL for order %s: %v", orderNumber, err)
+			return nil
+		}
+		resp, err := c.client.R().SetContext(ctx).Get(orderURL)
+		if err == nil {
+			return resp
+		}
+		if ctx.Err() != nil {
+			return nil
 		}
+		c.log.Errorf("failed to request accrual for order %s from %s: %v", orderNumber, address, err)
+		if c.endpoints.MarkDown(i, time.Now()) && c.endpoints.Len() > 1 {
+			c.log.Warnf("skipping accrual system %s for %s", address, endpointCooldown)
+		}
+	}
+	return nil
+}
+
+// sleep waits for d and reports false if ctx is cancelled first, so that a worker waiting out
+// a rate limit does not hold up shutdown.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// retryAfterDelay parses both Retry-After forms from RFC 9110: delay-seconds and an HTTP-date.
+// A date in the past means retrying right away; a missing or malformed header yields fallback.
+func retryAfterDelay(value string, now time.Time, fallback time.Duration) time.Duration {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, date.Sub(now))
 	}
+	return fallback
 }
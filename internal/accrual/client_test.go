@@ -0,0 +1,206 @@
+package accrual
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MarkMiraclee/gophermart/internal/config"
+	"github.com/MarkMiraclee/gophermart/internal/models"
+	"github.com/MarkMiraclee/gophermart/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// fakeStorage serves due orders and records what the poller writes back; methods the poller
+// does not call panic through the nil embedded interface.
+type fakeStorage struct {
+	storage.Storage
+
+	mu        sync.Mutex
+	orders    []models.Order
+	requested []models.OrderStatus
+	updates   []models.OrderUpdate
+	polled    []string
+}
+
+func (s *fakeStorage) GetOrdersByStatus(_ context.Context, statuses []models.OrderStatus, _ time.Time) ([]models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requested = append(s.requested, statuses...)
+	var due []models.Order
+	for _, order := range s.orders {
+		for _, status := range statuses {
+			if order.Status == status {
+				due = append(due, order)
+			}
+		}
+	}
+	return due, nil
+}
+
+func (s *fakeStorage) UpdateOrdersBatch(_ context.Context, updates []models.OrderUpdate) ([]models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updates = append(s.updates, updates...)
+	var updated []models.Order
+	for _, update := range updates {
+		for _, order := range s.orders {
+			if order.Number == update.Number {
+				order.Status, order.Accrual = update.Status, update.Accrual
+				updated = append(updated, order)
+			}
+		}
+	}
+	return updated, nil
+}
+
+func (s *fakeStorage) MarkOrdersPolled(_ context.Context, orderNumbers []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.polled = append(s.polled, orderNumbers...)
+	return nil
+}
+
+// newTestClient polls an accrual system that answers every order with the given status.
+func newTestClient(t *testing.T, s storage.Storage, accrualStatus string) (*Client, *test.Hook) {
+	t.Helper()
+	return newTestClientFor(t, s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"order":%q,"status":%q}`, number, accrualStatus)
+	}))
+}
+
+// newTestClientFor polls an accrual system served by handler.
+func newTestClientFor(t *testing.T, s storage.Storage, handler http.Handler) (*Client, *test.Hook) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{
+		AccrualSystemAddress: server.URL,
+		AccrualOrderPath:     config.DefaultAccrualOrderPath,
+		AccrualHTTPTimeout:   time.Second,
+		AccrualPollInterval:  config.DefaultAccrualPollInterval,
+		AccrualConcurrency:   1,
+		AccrualRetryBackoff:  config.DefaultAccrualRetryBackoff,
+	}
+	log, hook := test.NewNullLogger()
+	return NewClient(cfg, s, log, nil), hook
+}
+
+func TestUnknownStatusIsIgnored(t *testing.T) {
+	s := &fakeStorage{orders: []models.Order{{Number: "12345678903", Status: models.OrderStatusNew}}}
+	client, hook := newTestClient(t, s, "RECALCULATING")
+
+	client.processOrders(context.Background())
+
+	if len(s.updates) != 0 {
+		t.Errorf("stored updates %+v for an unknown status", s.updates)
+	}
+	if len(s.polled) != 1 || s.polled[0] != "12345678903" {
+		t.Errorf("polled = %v, want the order to stay pending", s.polled)
+	}
+	var warned bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, `unknown accrual status "RECALCULATING"`) {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Error("unknown status was not logged")
+	}
+}
+
+func TestRegisteredOrderStaysPending(t *testing.T) {
+	s := &fakeStorage{orders: []models.Order{{Number: "12345678903", Status: models.OrderStatusNew}}}
+	client, _ := newTestClient(t, s, "REGISTERED")
+
+	client.processOrders(context.Background())
+
+	want := models.OrderUpdate{Number: "12345678903", Status: models.OrderStatusProcessing}
+	if len(s.updates) != 1 || s.updates[0].Number != want.Number || s.updates[0].Status != want.Status || s.updates[0].Accrual != nil {
+		t.Fatalf("updates = %+v, want [%+v]", s.updates, want)
+	}
+	if len(s.polled) != 1 || s.polled[0] != "12345678903" {
+		t.Errorf("polled = %v, want the order to stay in the poll set", s.polled)
+	}
+
+	// Stored as PROCESSING, the order is due again on the next tick.
+	s.orders[0].Status = models.OrderStatusProcessing
+	s.polled = nil
+	client.processOrders(context.Background())
+	if len(s.polled) != 1 {
+		t.Errorf("PROCESSING order was not polled again: polled = %v", s.polled)
+	}
+}
+
+func TestTerminalOrdersAreNotPolled(t *testing.T) {
+	s := &fakeStorage{orders: []models.Order{
+		{Number: "12345678903", Status: models.OrderStatusNew},
+		{Number: "4561261212345467", Status: models.OrderStatusProcessed},
+		{Number: "79927398713", Status: models.OrderStatusInvalid},
+	}}
+	client, _ := newTestClient(t, s, "PROCESSING")
+
+	client.processOrders(context.Background())
+
+	for _, status := range s.requested {
+		if status.IsTerminal() {
+			t.Errorf("poller asked for %s orders", status)
+		}
+	}
+	if len(s.polled) != 1 || s.polled[0] != "12345678903" {
+		t.Errorf("polled = %v, want only the NEW order", s.polled)
+	}
+}
+
+type notifierFunc func(order models.Order)
+
+func (f notifierFunc) Notify(order models.Order) { f(order) }
+
+func TestProcessedOrderIsNotified(t *testing.T) {
+	s := &fakeStorage{orders: []models.Order{{Number: "12345678903", UserID: "user", Status: models.OrderStatusProcessing}}}
+	client, _ := newTestClient(t, s, "PROCESSED")
+	var notified []string
+	record := notifierFunc(func(order models.Order) { notified = append(notified, order.UserID+"/"+order.Number) })
+	client.notifier = Notifiers{record, record}
+
+	client.processOrders(context.Background())
+
+	if len(notified) != 2 || notified[0] != "user/12345678903" {
+		t.Errorf("notified = %v, want the credited order once per notifier", notified)
+	}
+}
+
+func TestRateLimitedRetriesAreBounded(t *testing.T) {
+	var requests atomic.Int32
+	s := &fakeStorage{orders: []models.Order{{Number: "12345678903", Status: models.OrderStatusNew}}}
+	client, _ := newTestClientFor(t, s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+
+	client.processOrders(context.Background())
+
+	if got := requests.Load(); got != rateLimitAttempts {
+		t.Errorf("accrual system got %d requests, want %d", got, rateLimitAttempts)
+	}
+	if len(s.updates) != 0 {
+		t.Errorf("stored updates %+v for a rate-limited order", s.updates)
+	}
+	if len(s.polled) != 1 {
+		t.Errorf("polled = %v, want the order to stay pending", s.polled)
+	}
+}
@@ -0,0 +1,57 @@
+package accrual
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Requests in flight usually hit a rate limit together, so at most one decrease per backoffCooldown is applied.
+	backoffCooldown = 5 * time.Second
+	// recoveryPeriod without rate limiting raises the limit by one worker.
+	recoveryPeriod = 30 * time.Second
+)
+
+// concurrencyController adapts the number of parallel accrual requests to 429 feedback:
+// rate limiting halves the limit and every quiet recoveryPeriod adds one worker back, up to max.
+type concurrencyController struct {
+	mu          sync.Mutex
+	max         int
+	limit       int
+	lastBackoff time.Time
+	quietSince  time.Time
+}
+
+func newConcurrencyController(limit int) *concurrencyController {
+	return &concurrencyController{max: limit, limit: limit}
+}
+
+// Limit returns the current number of workers, applying any recovery that is due.
+func (c *concurrencyController) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.limit < c.max {
+		steps := int(time.Since(c.quietSince) / recoveryPeriod)
+		if steps > 0 {
+			c.limit = min(c.max, c.limit+steps)
+			c.quietSince = time.Now()
+		}
+	}
+	return c.limit
+}
+
+// RateLimited records a 429 response and reports the new limit and whether it changed.
+func (c *concurrencyController) RateLimited() (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.quietSince = now
+	if c.limit == 1 || now.Sub(c.lastBackoff) < backoffCooldown {
+		return c.limit, false
+	}
+	c.limit = max(1, c.limit/2)
+	c.lastBackoff = now
+	return c.limit, true
+}
@@ -0,0 +1,65 @@
+package accrual
+
+import (
+	"sync"
+	"time"
+)
+
+// endpointCooldown is how long an accrual address is skipped after a connection error.
+const endpointCooldown = 30 * time.Second
+
+type endpoint struct {
+	address   string
+	downUntil time.Time
+}
+
+// endpoints rotates requests round-robin over the accrual system addresses, skipping those that
+// recently failed to connect.
+type endpoints struct {
+	mu   sync.Mutex
+	list []endpoint
+	next int
+}
+
+func newEndpoints(addresses []string) *endpoints {
+	e := &endpoints{list: make([]endpoint, len(addresses))}
+	for i, address := range addresses {
+		e.list[i].address = normalizeAddress(address)
+	}
+	return e
+}
+
+func (e *endpoints) Len() int {
+	return len(e.list)
+}
+
+// Pick returns the next healthy endpoint. When all are down, the one that is back soonest is
+// tried anyway, so that polling resumes as soon as any instance recovers.
+func (e *endpoints) Pick(now time.Time) (int, string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	soonest := -1
+	for range e.list {
+		i := e.next
+		e.next = (e.next + 1) % len(e.list)
+		if !now.Before(e.list[i].downUntil) {
+			return i, e.list[i].address
+		}
+		if soonest < 0 || e.list[i].downUntil.Before(e.list[soonest].downUntil) {
+			soonest = i
+		}
+	}
+	return soonest, e.list[soonest].address
+}
+
+// MarkDown takes endpoint i out of the rotation for endpointCooldown and reports whether it was
+// considered healthy until now.
+func (e *endpoints) MarkDown(i int, now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	wasUp := !now.Before(e.list[i].downUntil)
+	e.list[i].downUntil = now.Add(endpointCooldown)
+	return wasUp
+}
@@ -0,0 +1,36 @@
+package accrual
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pause holds back every worker while the accrual system is rate limiting us: a 429 seen by one
+// request applies to all of them, so the others should not keep sending requests meanwhile.
+type pause struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// Extend pauses requests for d from now, unless an earlier 429 already paused them for longer.
+func (p *pause) Extend(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(p.until) {
+		p.until = until
+	}
+}
+
+// Wait blocks until the pause is over and reports false if ctx is cancelled first.
+func (p *pause) Wait(ctx context.Context) bool {
+	p.mu.Lock()
+	remaining := time.Until(p.until)
+	p.mu.Unlock()
+
+	if remaining <= 0 {
+		return ctx.Err() == nil
+	}
+	return sleep(ctx, remaining)
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HasherBcrypt   = "bcrypt"
+	HasherArgon2id = "argon2id"
+
+	argon2idPrefix = "$argon2id$"
+)
+
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// PasswordHasher produces self-describing hashes: bcrypt hashes start with $2a$/$2b$/$2y$ and
+// argon2id hashes use the PHC string format, so the algorithm can be told from the stored value.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Compare(hash, password string) error
+	// NeedsRehash reports whether hash was made with another algorithm or other parameters.
+	NeedsRehash(hash string) bool
+}
+
+func NewPasswordHasher(algorithm string, bcryptCost int) (PasswordHasher, error) {
+	switch algorithm {
+	case HasherBcrypt:
+		return BcryptHasher{Cost: bcryptCost}, nil
+	case HasherArgon2id:
+		return DefaultArgon2idHasher(), nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", algorithm)
+	}
+}
+
+// ComparePassword checks password against a hash produced by any supported algorithm.
+func ComparePassword(hash, password string) error {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		return Argon2idHasher{}.Compare(hash, password)
+	}
+	return BcryptHasher{}.Compare(hash, password)
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h BcryptHasher) Compare(hash, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+	KeyLen  uint32
+	SaltLen int
+}
+
+// DefaultArgon2idHasher uses the parameters recommended by the x/crypto/argon2 documentation.
+func DefaultArgon2idHasher() Argon2idHasher {
+	return Argon2idHasher{Time: 1, Memory: 64 * 1024, Threads: 4, KeyLen: 32, SaltLen: 16}
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Compare verifies using the parameters encoded in hash, not the ones configured on h.
+func (h Argon2idHasher) Compare(hash, password string) error {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	actual := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return true
+	}
+	params, _, _, err := parseArgon2id(hash)
+	return err != nil || params != h
+}
+
+func parseArgon2id(hash string) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+	// "$argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>" splits into "", "argon2id", version, params, salt, key.
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HasherArgon2id {
+		return params, nil, nil, errors.New("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id key: %w", err)
+	}
+	params.SaltLen = len(salt)
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBcryptLengthLimit(t *testing.T) {
+	h := BcryptHasher{Cost: bcrypt.MinCost}
+
+	// 72 bytes, with a multi-byte rune last so that the limit is counted in bytes, not runes.
+	password := strings.Repeat("a", 70) + "é"
+	hash, err := h.Hash(password)
+	if err != nil {
+		t.Fatalf("hashing 72 bytes: %v", err)
+	}
+	if err := h.Compare(hash, password); err != nil {
+		t.Errorf("comparing 72 bytes: %v", err)
+	}
+	if err := h.Compare(hash, password[:71]); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("comparing a 71-byte prefix: err = %v, want %v", err, ErrPasswordMismatch)
+	}
+
+	if _, err := h.Hash(password + "b"); !errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		t.Errorf("hashing 73 bytes: err = %v, want %v", err, bcrypt.ErrPasswordTooLong)
+	}
+}
+
+func TestComparePasswordDetectsAlgorithm(t *testing.T) {
+	for _, h := range []PasswordHasher{
+		BcryptHasher{Cost: bcrypt.MinCost},
+		Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16},
+	} {
+		hash, err := h.Hash("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ComparePassword(hash, "secret"); err != nil {
+			t.Errorf("%T: ComparePassword = %v", h, err)
+		}
+		if err := ComparePassword(hash, "other"); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("%T: wrong password: err = %v, want %v", h, err, ErrPasswordMismatch)
+		}
+	}
+}
@@ -1,18 +1,68 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
+var (
+	ErrTokenExpired          = errors.New("token is expired")
+	ErrTokenInvalidSignature = errors.New("token signature is invalid")
+	ErrTokenInvalid          = errors.New("token is invalid")
+	ErrNoSigningKey          = errors.New("no signing key configured")
+)
+
 type Claims struct {
 	jwt.RegisteredClaims
 	UserID string
 }
 
-func BuildJWTString(userID string, secret string, lifetime time.Duration) (string, error) {
+type signingKey struct {
+	id     string
+	secret []byte
+}
+
+// Keyring holds the JWT secrets during a rotation: the first one signs new tokens and every
+// one is accepted for verification, so tokens issued before the rotation stay valid.
+type Keyring struct {
+	keys []signingKey
+}
+
+func NewKeyring(secrets []string) *Keyring {
+	k := &Keyring{}
+	for _, secret := range secrets {
+		k.keys = append(k.keys, signingKey{id: keyID(secret), secret: []byte(secret)})
+	}
+	return k
+}
+
+// keyID derives the kid header from a secret, so that it stays stable across restarts
+// without being configured separately.
+func keyID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (k *Keyring) key(id string) (signingKey, bool) {
+	for _, key := range k.keys {
+		if key.id == id {
+			return key, true
+		}
+	}
+	return signingKey{}, false
+}
+
+func BuildJWTString(userID string, keys *Keyring, lifetime time.Duration) (string, error) {
+	if len(keys.keys) == 0 {
+		return "", ErrNoSigningKey
+	}
+	signing := keys.keys[0]
+
 	claims := Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(lifetime)),
@@ -21,7 +71,8 @@ func BuildJWTString(userID string, secret string, lifetime time.Duration) (strin
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(secret))
+	token.Header["kid"] = signing.id
+	tokenString, err := token.SignedString(signing.secret)
 	if err != nil {
 		return "", err
 	}
@@ -29,22 +80,81 @@ func BuildJWTString(userID string, secret string, lifetime time.Duration) (strin
 	return tokenString, nil
 }
 
-func GetUserID(tokenString string, secret string) (string, error) {
+// GetUserID verifies the token and returns its user. Tokens with a kid header are checked
+// against that key only; older tokens without one are tried against every key. Time-based
+// claims are checked with the given leeway so that clocks slightly out of sync do not reject
+// tokens at the boundaries.
+func GetUserID(tokenString string, keys *Keyring, leeway time.Duration) (string, error) {
+	// Claims are validated below, with leeway; jwt/v4 has no leeway option of its own.
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+
+	candidates := keys.keys
+	// The header only selects the key; the signature is verified by parseToken.
+	if unverified, _, err := parser.ParseUnverified(tokenString, &Claims{}); err == nil {
+		if kid, ok := unverified.Header["kid"].(string); ok {
+			key, found := keys.key(kid)
+			if !found {
+				// Signed with a key that has been rotated out.
+				return "", ErrTokenInvalidSignature
+			}
+			candidates = []signingKey{key}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", ErrNoSigningKey
+	}
+
+	var (
+		claims *Claims
+		err    error
+	)
+	for _, key := range candidates {
+		claims, err = parseToken(parser, tokenString, key.secret)
+		if !errors.Is(err, ErrTokenInvalidSignature) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	now := jwt.TimeFunc()
+	if !claims.VerifyExpiresAt(now.Add(-leeway), false) {
+		return "", ErrTokenExpired
+	}
+	if !claims.VerifyNotBefore(now.Add(leeway), false) || !claims.VerifyIssuedAt(now.Add(leeway), false) {
+		return "", fmt.Errorf("%w: token used before it was issued", ErrTokenInvalid)
+	}
+
+	return claims.UserID, nil
+}
+
+func parseToken(parser *jwt.Parser, tokenString string, secret []byte) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+	token, err := parser.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
-		return []byte(secret), nil
+		return secret, nil
 	})
 
 	if err != nil {
-		return "", fmt.Errorf("failed to parse token: %w", err)
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) {
+			// A forged token is never reported as merely expired.
+			switch {
+			case validationErr.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+				return nil, ErrTokenInvalidSignature
+			case validationErr.Errors&jwt.ValidationErrorExpired != 0:
+				return nil, ErrTokenExpired
+			}
+		}
+		return nil, fmt.Errorf("%w: %w", ErrTokenInvalid, err)
 	}
 
 	if !token.Valid {
-		return "", fmt.Errorf("token is not valid")
+		return nil, ErrTokenInvalid
 	}
 
-	return claims.UserID, nil
+	return claims, nil
 }
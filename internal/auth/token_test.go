@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func signClaims(t *testing.T, keys *Keyring, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{RegisteredClaims: claims, UserID: "user"})
+	token.Header["kid"] = keys.keys[0].id
+	signed, err := token.SignedString(keys.keys[0].secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestGetUserIDLeeway(t *testing.T) {
+	const leeway = 30 * time.Second
+	// Margins of a few seconds, since NumericDate truncates to whole seconds.
+	const margin = 5 * time.Second
+	keys := NewKeyring([]string{"secret"})
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		claims  jwt.RegisteredClaims
+		wantErr error
+	}{
+		{"expired inside leeway", jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now.Add(-leeway + margin))}, nil},
+		{"expired outside leeway", jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now.Add(-leeway - margin))}, ErrTokenExpired},
+		{"not yet valid inside leeway", jwt.RegisteredClaims{NotBefore: jwt.NewNumericDate(now.Add(leeway - margin))}, nil},
+		{"not yet valid outside leeway", jwt.RegisteredClaims{NotBefore: jwt.NewNumericDate(now.Add(leeway + margin))}, ErrTokenInvalid},
+		{"issued in the future inside leeway", jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(now.Add(leeway - margin))}, nil},
+		{"issued in the future outside leeway", jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(now.Add(leeway + margin))}, ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := GetUserID(signClaims(t, keys, tt.claims), keys, leeway)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || userID != "user" {
+				t.Fatalf("GetUserID = %q, %v; want user, nil", userID, err)
+			}
+		})
+	}
+}
+
+func TestGetUserIDWithoutLeewayRejectsExpired(t *testing.T) {
+	keys := NewKeyring([]string{"secret"})
+	token, err := BuildJWTString("user", keys, -2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetUserID(token, keys, 0); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("err = %v, want %v", err, ErrTokenExpired)
+	}
+}
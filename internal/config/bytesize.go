@@ -0,0 +1,90 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes that parses from env, flags and the config file as a plain number
+// of bytes or with a unit: B, KB, MB or GB. Units are powers of 1024; KiB, MiB and GiB are
+// accepted as well.
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	// Longer suffixes first, so that "KB" is not read as "K" followed by "B".
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30},
+	{"b", 1},
+}
+
+func ParseByteSize(s string) (ByteSize, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	unit := ByteSize(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value, unit = strings.TrimSpace(strings.TrimSuffix(value, u.suffix)), u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: want a non-negative number of bytes, optionally with a unit such as KB or MB", s)
+	}
+	if n > int64(^uint64(0)>>1)/int64(unit) {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return ByteSize(n) * unit, nil
+}
+
+// String formats the size with the largest unit that divides it exactly.
+func (b ByteSize) String() string {
+	switch {
+	case b == 0:
+		return "0B"
+	case b%(1<<30) == 0:
+		return strconv.FormatInt(int64(b>>30), 10) + "GB"
+	case b%(1<<20) == 0:
+		return strconv.FormatInt(int64(b>>20), 10) + "MB"
+	case b%(1<<10) == 0:
+		return strconv.FormatInt(int64(b>>10), 10) + "KB"
+	}
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
+
+// Set implements flag.Value.
+func (b *ByteSize) Set(s string) error {
+	size, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// UnmarshalText is used by the env parser and, for JSON strings, by the config file loader.
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	return b.Set(string(text))
+}
+
+// UnmarshalJSON also accepts a plain JSON number of bytes.
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	if n, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		if n < 0 {
+			return fmt.Errorf("invalid size %d: must not be negative", n)
+		}
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("size must be a number of bytes or a string such as \"1MB\": %w", err)
+	}
+	return b.Set(s)
+}
@@ -1,36 +1,445 @@
 package config
 
 import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/caarlos0/env/v6"
 )
 
 const (
 	DefaultRunAddress           = "localhost:8080"
 	DefaultDatabaseURI          = ""
+	DefaultDatabaseReplicaURI   = ""
+	DefaultDBMaxConnIdleTime    = 5 * time.Minute
+	DefaultDBHealthCheckPeriod  = 30 * time.Second
 	DefaultAccrualSystemAddress = ""
 	DefaultJWTSecret            = "supersecretkey"
+	DefaultJWTLeeway            = 30 * time.Second
+	DefaultAuthVerifyUser       = false
+	DefaultPasswordHasher       = "bcrypt"
+	DefaultBcryptCost           = 10
+	DefaultTLSCertFile          = ""
+	DefaultTLSKeyFile           = ""
+	DefaultWebhookURL           = ""
+	DefaultWebhookSecret        = ""
+	DefaultServerReadTimeout    = 15 * time.Second
+	DefaultServerWriteTimeout   = 30 * time.Second
+	DefaultServerIdleTimeout    = 60 * time.Second
+	DefaultRequestTimeout       = 10 * time.Second
+	DefaultMaxBodySize          = ByteSize(1 << 20)
+	DefaultMaxInFlight          = 0
+	DefaultGzipLevel            = gzip.BestSpeed
+	DefaultGzipMinSize          = ByteSize(1 << 10)
+	DefaultBalanceCacheTTL      = time.Duration(0)
+	DefaultUserRateLimit        = 10.0
+	DefaultMaxOrdersPerUser     = 0
+	DefaultUserRateBurst        = 20
+	DefaultMaxWithdrawal        = 0.0
+	DefaultInternalAddress      = ""
+	DefaultPprofEnabled         = false
+	DefaultPprofAddress         = "localhost:6060"
+	DefaultLogLevel             = "info"
+	DefaultLogFormat            = "json"
+	DefaultConfigFile           = ""
+	DefaultAccrualHTTPTimeout   = 5 * time.Second
+	DefaultAccrualPollInterval  = 1 * time.Second
+	DefaultAccrualMaxOrderAge   = time.Duration(0)
+	DefaultAccrualConcurrency   = 10
+	DefaultAccrualRetryBackoff  = 1 * time.Second
+	DefaultAccrualDisabled      = false
+	DefaultAccrualUserAgent     = "gophermart"
+	DefaultAccrualOrderPath     = "/api/orders/%s"
 )
 
 type Config struct {
-	RunAddress           string `env:"RUN_ADDRESS"`
-	DatabaseURI          string `env:"DATABASE_URI"`
-	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
-	JWTSecret            string `env:"JWT_SECRET"`
+	RunAddress           string        `env:"RUN_ADDRESS" json:"run_address"`
+	DatabaseURI          string        `env:"DATABASE_URI" json:"database_uri"`
+	DatabaseReplicaURI   string        `env:"DATABASE_REPLICA_URI" json:"database_replica_uri"`
+	DBMaxConnIdleTime    time.Duration `env:"DB_MAX_CONN_IDLE_TIME" json:"db_max_conn_idle_time"`
+	DBHealthCheckPeriod  time.Duration `env:"DB_HEALTH_CHECK_PERIOD" json:"db_health_check_period"`
+	AccrualSystemAddress string        `env:"ACCRUAL_SYSTEM_ADDRESS" json:"accrual_system_address"`
+	JWTSecret            string        `env:"JWT_SECRET" json:"jwt_secret"`
+	JWTLeeway            time.Duration `env:"JWT_LEEWAY" json:"jwt_leeway"`
+	AuthVerifyUser       bool          `env:"AUTH_VERIFY_USER" json:"auth_verify_user"`
+	PasswordHasher       string        `env:"PASSWORD_HASHER" json:"password_hasher"`
+	BcryptCost           int           `env:"BCRYPT_COST" json:"bcrypt_cost"`
+	TLSCertFile          string        `env:"TLS_CERT_FILE" json:"tls_cert_file"`
+	TLSKeyFile           string        `env:"TLS_KEY_FILE" json:"tls_key_file"`
+	AccrualHTTPTimeout   time.Duration `env:"ACCRUAL_HTTP_TIMEOUT" json:"accrual_http_timeout"`
+	AccrualPollInterval  time.Duration `env:"ACCRUAL_POLL_INTERVAL" json:"accrual_poll_interval"`
+	AccrualMaxOrderAge   time.Duration `env:"ACCRUAL_MAX_ORDER_AGE" json:"accrual_max_order_age"`
+	AccrualConcurrency   int           `env:"ACCRUAL_CONCURRENCY" json:"accrual_concurrency"`
+	AccrualRetryBackoff  time.Duration `env:"ACCRUAL_RETRY_BACKOFF" json:"accrual_retry_backoff"`
+	AccrualDisabled      bool          `env:"ACCRUAL_DISABLED" json:"accrual_disabled"`
+	AccrualUserAgent     string        `env:"ACCRUAL_USER_AGENT" json:"accrual_user_agent"`
+	AccrualOrderPath     string        `env:"ACCRUAL_ORDER_PATH" json:"accrual_order_path"`
+	AccrualHeaders       []string      `env:"ACCRUAL_HEADERS" envSeparator:"," json:"accrual_headers"`
+	CORSAllowedOrigins   []string      `env:"CORS_ALLOWED_ORIGINS" envSeparator:"," json:"cors_allowed_origins"`
+	WebhookURL           string        `env:"WEBHOOK_URL" json:"webhook_url"`
+	WebhookSecret        string        `env:"WEBHOOK_SECRET" json:"webhook_secret"`
+	ServerReadTimeout    time.Duration `env:"SERVER_READ_TIMEOUT" json:"server_read_timeout"`
+	ServerWriteTimeout   time.Duration `env:"SERVER_WRITE_TIMEOUT" json:"server_write_timeout"`
+	ServerIdleTimeout    time.Duration `env:"SERVER_IDLE_TIMEOUT" json:"server_idle_timeout"`
+	RequestTimeout       time.Duration `env:"REQUEST_TIMEOUT" json:"request_timeout"`
+	MaxBodySize          ByteSize      `env:"MAX_BODY_SIZE" json:"max_body_size"`
+	MaxInFlight          int           `env:"MAX_CONCURRENT_REQUESTS" json:"max_concurrent_requests"`
+	GzipLevel            int           `env:"GZIP_LEVEL" json:"gzip_level"`
+	GzipMinSize          ByteSize      `env:"GZIP_MIN_SIZE" json:"gzip_min_size"`
+	BalanceCacheTTL      time.Duration `env:"BALANCE_CACHE_TTL" json:"balance_cache_ttl"`
+	UserRateLimit        float64       `env:"USER_RATE_LIMIT" json:"user_rate_limit"`
+	UserRateBurst        int           `env:"USER_RATE_BURST" json:"user_rate_burst"`
+	MaxOrdersPerUser     int           `env:"MAX_ORDERS_PER_USER" json:"max_orders_per_user"`
+	MaxWithdrawal        float64       `env:"MAX_WITHDRAWAL_PER_REQUEST" json:"max_withdrawal_per_request"`
+	InternalAddress      string        `env:"INTERNAL_ADDRESS" json:"internal_address"`
+	PprofEnabled         bool          `env:"PPROF_ENABLED" json:"pprof_enabled"`
+	PprofAddress         string        `env:"PPROF_ADDRESS" json:"pprof_address"`
+	LogLevel             string        `env:"LOG_LEVEL" json:"log_level"`
+	LogFormat            string        `env:"LOG_FORMAT" json:"log_format"`
+	ConfigFile           string        `env:"CONFIG" json:"-"`
 }
 
+// New builds the configuration with precedence flags > env > config file > defaults.
 func New() (*Config, error) {
 	cfg := &Config{}
 
 	flag.StringVar(&cfg.RunAddress, "a", DefaultRunAddress, "server address")
 	flag.StringVar(&cfg.DatabaseURI, "d", DefaultDatabaseURI, "database URI")
-	flag.StringVar(&cfg.AccrualSystemAddress, "r", DefaultAccrualSystemAddress, "accrual system address")
+	flag.StringVar(&cfg.DatabaseReplicaURI, "database-replica", DefaultDatabaseReplicaURI, "read-only replica database URI")
+	flag.DurationVar(&cfg.DBMaxConnIdleTime, "db-max-conn-idle-time", DefaultDBMaxConnIdleTime, "close pooled database connections idle for longer than this")
+	flag.DurationVar(&cfg.DBHealthCheckPeriod, "db-health-check-period", DefaultDBHealthCheckPeriod, "how often idle pooled database connections are checked")
+	flag.StringVar(&cfg.AccrualSystemAddress, "r", DefaultAccrualSystemAddress, "accrual system address; a comma-separated list fails over between instances")
 	flag.StringVar(&cfg.JWTSecret, "j", DefaultJWTSecret, "jwt secret key")
+	flag.DurationVar(&cfg.JWTLeeway, "jwt-leeway", DefaultJWTLeeway, "tolerated clock skew when validating token times")
+	flag.BoolVar(&cfg.AuthVerifyUser, "auth-verify-user", DefaultAuthVerifyUser, "check that the token's user still exists on every request")
+	flag.StringVar(&cfg.PasswordHasher, "password-hasher", DefaultPasswordHasher, "password hashing algorithm for new hashes: bcrypt or argon2id")
+	flag.IntVar(&cfg.BcryptCost, "bcrypt-cost", DefaultBcryptCost, "bcrypt cost for new password hashes")
+	flag.StringVar(&cfg.TLSCertFile, "tls-cert", DefaultTLSCertFile, "TLS certificate file")
+	flag.StringVar(&cfg.TLSKeyFile, "tls-key", DefaultTLSKeyFile, "TLS private key file")
+	flag.DurationVar(&cfg.AccrualHTTPTimeout, "accrual-timeout", DefaultAccrualHTTPTimeout, "accrual system request timeout")
+	flag.DurationVar(&cfg.AccrualPollInterval, "accrual-poll-interval", DefaultAccrualPollInterval, "how often the accrual poller looks for due orders")
+	flag.StringVar(&cfg.WebhookURL, "webhook-url", DefaultWebhookURL, "URL notified when an order is processed")
+	flag.StringVar(&cfg.WebhookSecret, "webhook-secret", DefaultWebhookSecret, "HMAC secret for signing webhook payloads")
+	flag.DurationVar(&cfg.ServerReadTimeout, "read-timeout", DefaultServerReadTimeout, "HTTP server read timeout")
+	flag.DurationVar(&cfg.ServerWriteTimeout, "write-timeout", DefaultServerWriteTimeout, "HTTP server write timeout")
+	flag.DurationVar(&cfg.ServerIdleTimeout, "idle-timeout", DefaultServerIdleTimeout, "HTTP server idle timeout")
+	flag.DurationVar(&cfg.RequestTimeout, "request-timeout", DefaultRequestTimeout, "per-request handler timeout (0 disables)")
+	cfg.MaxBodySize = DefaultMaxBodySize
+	flag.Var(&cfg.MaxBodySize, "max-body-size", "largest accepted request body after decompression, e.g. 512KB or 1MB")
+	flag.IntVar(&cfg.GzipLevel, "gzip-level", DefaultGzipLevel, "gzip level of compressed responses, 1 (fastest) to 9 (smallest)")
+	cfg.GzipMinSize = DefaultGzipMinSize
+	flag.Var(&cfg.GzipMinSize, "gzip-min-size", "smallest response body that is compressed, e.g. 1KB")
+	flag.IntVar(&cfg.MaxInFlight, "max-concurrent-requests", DefaultMaxInFlight, "requests served at once before new ones get 503 (0 means unlimited)")
+	flag.DurationVar(&cfg.BalanceCacheTTL, "balance-cache-ttl", DefaultBalanceCacheTTL, "how old a cached balance served during database errors may be (0 disables)")
+	flag.Float64Var(&cfg.UserRateLimit, "user-rate-limit", DefaultUserRateLimit, "sustained requests per second allowed per user (0 disables)")
+	flag.IntVar(&cfg.UserRateBurst, "user-rate-burst", DefaultUserRateBurst, "requests a user may send in a burst above the rate limit")
+	flag.IntVar(&cfg.MaxOrdersPerUser, "max-orders-per-user", DefaultMaxOrdersPerUser, "maximum number of orders a user may upload (0 means unlimited)")
+	flag.StringVar(&cfg.InternalAddress, "internal-address", DefaultInternalAddress, "listen address of the metrics, admin and pprof routes (empty keeps them on the server address)")
+	flag.BoolVar(&cfg.PprofEnabled, "pprof-enabled", DefaultPprofEnabled, "serve net/http/pprof on the pprof address")
+	flag.StringVar(&cfg.PprofAddress, "pprof-address", DefaultPprofAddress, "listen address of the pprof endpoints")
+	flag.Float64Var(&cfg.MaxWithdrawal, "max-withdrawal-per-request", DefaultMaxWithdrawal, "largest sum a single withdrawal may request (0 means unlimited)")
+	flag.StringVar(&cfg.LogLevel, "log-level", DefaultLogLevel, "log level: debug, info, warn or error")
+	flag.StringVar(&cfg.LogFormat, "log-format", DefaultLogFormat, "log format: json or text")
+	flag.DurationVar(&cfg.AccrualMaxOrderAge, "accrual-max-order-age", DefaultAccrualMaxOrderAge, "mark orders pending longer than this as INVALID (0 disables)")
+	flag.IntVar(&cfg.AccrualConcurrency, "accrual-concurrency", DefaultAccrualConcurrency, "maximum number of parallel accrual system requests")
+	flag.DurationVar(&cfg.AccrualRetryBackoff, "accrual-retry-backoff", DefaultAccrualRetryBackoff, "pause after a 429 from the accrual system without a Retry-After header")
+	flag.BoolVar(&cfg.AccrualDisabled, "accrual-disabled", DefaultAccrualDisabled, "do not poll the accrual system")
+	flag.StringVar(&cfg.AccrualUserAgent, "accrual-user-agent", DefaultAccrualUserAgent, "User-Agent sent to the accrual system")
+	flag.StringVar(&cfg.AccrualOrderPath, "accrual-order-path", DefaultAccrualOrderPath, "accrual system path of an order, with %s for the order number")
+	flag.StringVar(&cfg.ConfigFile, "c", DefaultConfigFile, "path to JSON config file")
 	flag.Parse()
 
+	// Remember explicitly passed flags so they can be re-applied on top of file and env values.
+	setFlags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+
+	configFile := cfg.ConfigFile
+	if _, ok := setFlags["c"]; !ok {
+		if v, ok := os.LookupEnv("CONFIG"); ok {
+			configFile = v
+		}
+	}
+	if configFile != "" {
+		if err := loadFile(cfg, configFile); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := env.Parse(cfg); err != nil {
 		return nil, err
 	}
 
+	for name, value := range setFlags {
+		if err := flag.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
 	return cfg, nil
 }
+
+// Validate reports missing or malformed required settings.
+func (c *Config) Validate() error {
+	if c.RunAddress == "" {
+		return errors.New("RUN_ADDRESS must not be empty")
+	}
+	if err := validateListenAddress(c.RunAddress); err != nil {
+		return fmt.Errorf("RUN_ADDRESS: %w", err)
+	}
+	if c.DatabaseURI == "" {
+		return errors.New("DATABASE_URI must not be empty")
+	}
+	if c.DBMaxConnIdleTime <= 0 || c.DBHealthCheckPeriod <= 0 {
+		return errors.New("DB_MAX_CONN_IDLE_TIME and DB_HEALTH_CHECK_PERIOD must be positive")
+	}
+	if c.AccrualEnabled() {
+		for _, address := range c.AccrualAddresses() {
+			// A missing scheme defaults to http, matching the accrual client's normalization.
+			accrualAddress := address
+			if !strings.Contains(accrualAddress, "://") {
+				accrualAddress = "http://" + accrualAddress
+			}
+			u, err := url.Parse(accrualAddress)
+			if err != nil {
+				return fmt.Errorf("ACCRUAL_SYSTEM_ADDRESS is not a valid URL: %w", err)
+			}
+			if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("ACCRUAL_SYSTEM_ADDRESS must be an http(s) URL, got %q", address)
+			}
+		}
+	}
+	if c.AccrualHTTPTimeout <= 0 {
+		return errors.New("ACCRUAL_HTTP_TIMEOUT must be positive")
+	}
+	if c.AccrualPollInterval <= 0 {
+		return errors.New("ACCRUAL_POLL_INTERVAL must be positive")
+	}
+	if c.AccrualMaxOrderAge < 0 {
+		return errors.New("ACCRUAL_MAX_ORDER_AGE must not be negative")
+	}
+	if _, err := c.AccrualRequestHeaders(); err != nil {
+		return err
+	}
+	// The template is passed to fmt, so it must contain the number placeholder and no other verb.
+	if strings.Count(c.AccrualOrderPath, "%") != 1 || !strings.Contains(c.AccrualOrderPath, "%s") {
+		return fmt.Errorf("ACCRUAL_ORDER_PATH must contain exactly one %%s for the order number, got %q", c.AccrualOrderPath)
+	}
+	if c.AccrualConcurrency <= 0 {
+		return errors.New("ACCRUAL_CONCURRENCY must be positive")
+	}
+	if c.AccrualRetryBackoff <= 0 {
+		return errors.New("ACCRUAL_RETRY_BACKOFF must be positive")
+	}
+	if c.JWTSecret == "" {
+		return errors.New("JWT_SECRET must not be empty")
+	}
+	for _, secret := range strings.Split(c.JWTSecret, ",") {
+		if strings.TrimSpace(secret) == "" {
+			return errors.New("JWT_SECRET must not contain empty secrets")
+		}
+	}
+	if c.JWTLeeway < 0 {
+		return errors.New("JWT_LEEWAY must not be negative")
+	}
+	switch c.PasswordHasher {
+	case "bcrypt", "argon2id":
+	default:
+		return fmt.Errorf("PASSWORD_HASHER must be bcrypt or argon2id, got %q", c.PasswordHasher)
+	}
+	// bcrypt.MinCost and bcrypt.MaxCost.
+	if c.BcryptCost < 4 || c.BcryptCost > 31 {
+		return fmt.Errorf("BCRYPT_COST must be between 4 and 31, got %d", c.BcryptCost)
+	}
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("WEBHOOK_URL must be an absolute http(s) URL, got %q", c.WebhookURL)
+		}
+	}
+	if c.ServerReadTimeout <= 0 || c.ServerWriteTimeout <= 0 || c.ServerIdleTimeout <= 0 {
+		return errors.New("SERVER_READ_TIMEOUT, SERVER_WRITE_TIMEOUT and SERVER_IDLE_TIMEOUT must be positive")
+	}
+	if c.RequestTimeout < 0 {
+		return errors.New("REQUEST_TIMEOUT must not be negative")
+	}
+	if c.MaxBodySize <= 0 {
+		return errors.New("MAX_BODY_SIZE must be positive")
+	}
+	if c.GzipLevel < gzip.HuffmanOnly || c.GzipLevel > gzip.BestCompression {
+		return fmt.Errorf("GZIP_LEVEL must be between %d and %d, got %d", gzip.HuffmanOnly, gzip.BestCompression, c.GzipLevel)
+	}
+	if c.MaxInFlight < 0 {
+		return errors.New("MAX_CONCURRENT_REQUESTS must not be negative")
+	}
+	if c.BalanceCacheTTL < 0 {
+		return errors.New("BALANCE_CACHE_TTL must not be negative")
+	}
+	if c.UserRateLimit < 0 {
+		return errors.New("USER_RATE_LIMIT must not be negative")
+	}
+	if c.UserRateLimit > 0 && c.UserRateBurst <= 0 {
+		return errors.New("USER_RATE_BURST must be positive")
+	}
+	if c.MaxOrdersPerUser < 0 {
+		return errors.New("MAX_ORDERS_PER_USER must not be negative")
+	}
+	if c.MaxWithdrawal < 0 || math.IsInf(c.MaxWithdrawal, 0) || math.IsNaN(c.MaxWithdrawal) {
+		return errors.New("MAX_WITHDRAWAL_PER_REQUEST must be a non-negative number")
+	}
+	if c.InternalAddress != "" {
+		if err := validateListenAddress(c.InternalAddress); err != nil {
+			return fmt.Errorf("INTERNAL_ADDRESS: %w", err)
+		}
+		if c.InternalAddress == c.RunAddress {
+			return errors.New("INTERNAL_ADDRESS must differ from RUN_ADDRESS")
+		}
+	}
+	// With an internal listener pprof is served there and PPROF_ADDRESS is not used.
+	if c.PprofEnabled && c.InternalAddress == "" {
+		if err := validateListenAddress(c.PprofAddress); err != nil {
+			return fmt.Errorf("PPROF_ADDRESS: %w", err)
+		}
+		if c.PprofAddress == c.RunAddress {
+			return errors.New("PPROF_ADDRESS must differ from RUN_ADDRESS")
+		}
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("LOG_LEVEL must be one of debug, info, warn, error, got %q", c.LogLevel)
+	}
+	switch c.LogFormat {
+	case "json", "text":
+	default:
+		return fmt.Errorf("LOG_FORMAT must be json or text, got %q", c.LogFormat)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("both TLS_CERT_FILE and TLS_KEY_FILE must be set to enable TLS")
+	}
+	return nil
+}
+
+// JWTSecrets splits JWT_SECRET into the rotation keyring; the first secret signs new tokens.
+func (c *Config) JWTSecrets() []string {
+	secrets := strings.Split(c.JWTSecret, ",")
+	for i, secret := range secrets {
+		secrets[i] = strings.TrimSpace(secret)
+	}
+	return secrets
+}
+
+// AccrualEnabled reports whether orders should be polled; an empty address disables polling too.
+func (c *Config) AccrualEnabled() bool {
+	return !c.AccrualDisabled && len(c.AccrualAddresses()) > 0
+}
+
+// AccrualAddresses splits ACCRUAL_SYSTEM_ADDRESS into the redundant accrual system instances.
+func (c *Config) AccrualAddresses() []string {
+	var addresses []string
+	for _, address := range strings.Split(c.AccrualSystemAddress, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// AccrualRequestHeaders parses ACCRUAL_HEADERS entries of the form "Name: value".
+func (c *Config) AccrualRequestHeaders() (http.Header, error) {
+	headers := make(http.Header, len(c.AccrualHeaders))
+	for _, entry := range c.AccrualHeaders {
+		name, value, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("ACCRUAL_HEADERS entries must look like \"Name: value\", got %q", entry)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if data, err = parseFileDurations(data); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return nil
+}
+
+// parseFileDurations rewrites duration strings such as "30s" in a config file to the
+// nanosecond counts encoding/json expects, so durations are written the same way everywhere.
+// Plain numbers are left as they are.
+func parseFileDurations(data []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		// Not an object; let the real decode report it.
+		return data, nil
+	}
+
+	durationType := reflect.TypeOf(time.Duration(0))
+	t := reflect.TypeOf(Config{})
+	changed := false
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		raw, ok := fields[name]
+		if field.Type != durationType || !ok {
+			continue
+		}
+		var s string
+		if json.Unmarshal(raw, &s) != nil {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		fields[name] = json.RawMessage(strconv.FormatInt(int64(d), 10))
+		changed = true
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(fields)
+}
+
+// validateListenAddress accepts what http.Server.Addr does: host:port with an optional host,
+// e.g. ":8080", "0.0.0.0:8080" or "[::]:8080". IPv6 hosts must be bracketed.
+func validateListenAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("must be host:port: %w", err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
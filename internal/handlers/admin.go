@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/MarkMiraclee/gophermart/internal/middlewares"
+)
+
+const (
+	defaultUsersPageSize = 100
+	maxUsersPageSize     = 1000
+)
+
+func (a *API) ListUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit == 0 {
+		limit = defaultUsersPageSize
+	}
+	if limit > maxUsersPageSize {
+		limit = maxUsersPageSize
+	}
+
+	users, err := a.storage.ListUsers(r.Context(), limit, offset)
+	if err != nil {
+		a.log.Errorf("failed to list users: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+
+	if len(users) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(users); err != nil {
+		a.log.Errorf("failed to encode users: %v", err)
+	}
+}
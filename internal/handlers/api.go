@@ -3,89 +3,122 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/MarkMiraclee/gophermart/internal/auth"
+	"github.com/MarkMiraclee/gophermart/internal/config"
+	"github.com/MarkMiraclee/gophermart/internal/logger"
 	"github.com/MarkMiraclee/gophermart/internal/luhn"
+	"github.com/MarkMiraclee/gophermart/internal/metrics"
 	"github.com/MarkMiraclee/gophermart/internal/middlewares"
 	"github.com/MarkMiraclee/gophermart/internal/models"
 	"github.com/MarkMiraclee/gophermart/internal/storage"
-	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/go-chi/chi/v5"
 )
 
 const (
 	jwtLifetime = 24 * time.Hour
+	// maxLoginLength matches the users.login VARCHAR(255) column.
+	maxLoginLength = 255
+	// maxPasswordBytes is the bcrypt input limit. Longer passwords are rejected rather than
+	// pre-hashed so that stored hashes stay plain bcrypt; the limit holds for every hasher so
+	// that switching PASSWORD_HASHER never changes which passwords are accepted.
+	maxPasswordBytes = 72
+	maxBatchOrders   = 1000
 )
 
 type API struct {
-	storage   storage.Storage
-	log       *logrus.Logger
-	jwtSecret string
+	storage storage.Storage
+	log     logger.Logger
+	cfg     *config.Config
+	hasher  auth.PasswordHasher
+	keys    *auth.Keyring
+	// balances is the fallback for GetBalance when the database fails.
+	balances *balanceCache
 }
 
-func NewAPI(s storage.Storage, log *logrus.Logger, jwtSecret string) *API {
+func NewAPI(s storage.Storage, log logger.Logger, cfg *config.Config, hasher auth.PasswordHasher) *API {
 	return &API{
-		storage:   s,
-		log:       log,
-		jwtSecret: jwtSecret,
+		storage: s,
+		log:     log,
+		cfg:     cfg,
+		hasher:  hasher,
+		keys:    auth.NewKeyring(cfg.JWTSecrets()),
+
+		balances: newBalanceCache(cfg.BalanceCacheTTL),
 	}
 }
 
 func (a *API) Register(w http.ResponseWriter, r *http.Request) {
 	var req models.RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request format", http.StatusBadRequest)
+	if err := decodeStrictJSON(r.Body, &req); err != nil {
+		requestBodyError(w, err, err.Error())
 		return
 	}
+	req.Login = models.NormalizeLogin(req.Login)
 
 	if req.Login == "" || req.Password == "" {
 		http.Error(w, "login and password must not be empty", http.StatusBadRequest)
 		return
 	}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if utf8.RuneCountInString(req.Login) > maxLoginLength {
+		http.Error(w, fmt.Sprintf("login must be at most %d characters long", maxLoginLength), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Password) > maxPasswordBytes {
+		http.Error(w, fmt.Sprintf("password must be at most %d bytes long", maxPasswordBytes), http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := a.hasher.Hash(req.Password)
 	if err != nil {
 		a.log.Errorf("failed to hash password: %v", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	user, err := a.storage.CreateUser(r.Context(), req.Login, string(passwordHash))
+	user, err := a.storage.CreateUser(r.Context(), req.Login, passwordHash)
 	if err != nil {
 		if errors.Is(err, storage.ErrLoginExists) {
 			http.Error(w, "login already exists", http.StatusConflict)
 			return
 		}
 		a.log.Errorf("failed to create user: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	token, err := auth.BuildJWTString(user.ID, a.jwtSecret, jwtLifetime)
-	if err != nil {
-		a.log.Errorf("failed to build JWT: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		middlewares.StorageError(w, err)
 		return
 	}
 
-	w.Header().Set("Authorization", "Bearer "+token)
-	w.WriteHeader(http.StatusOK)
+	a.writeToken(w, user.ID)
 }
 
 func (a *API) Login(w http.ResponseWriter, r *http.Request) {
 	var req models.LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request format", http.StatusBadRequest)
+	if err := decodeStrictJSON(r.Body, &req); err != nil {
+		requestBodyError(w, err, err.Error())
+		return
+	}
+
+	// Registration never accepts passwords over the bcrypt limit, so such a password cannot match;
+	// rejecting it here also stops a long password from matching on its first 72 bytes only.
+	if len(req.Password) > maxPasswordBytes {
+		http.Error(w, "invalid login/password pair", http.StatusUnauthorized)
 		return
 	}
 
-	user, err := a.storage.GetUserByLogin(r.Context(), req.Login)
+	user, err := a.storage.GetUserByLogin(r.Context(), models.NormalizeLogin(req.Login))
 	if err != nil {
 		a.log.Errorf("failed to get user: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		middlewares.StorageError(w, err)
 		return
 	}
 	if user == nil {
@@ -93,12 +126,73 @@ func (a *API) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+	if err := auth.ComparePassword(user.PasswordHash, req.Password); err != nil {
+		if !errors.Is(err, auth.ErrPasswordMismatch) {
+			a.log.Errorf("failed to verify password hash of user %s: %v", user.ID, err)
+		}
 		http.Error(w, "invalid login/password pair", http.StatusUnauthorized)
 		return
 	}
 
-	token, err := auth.BuildJWTString(user.ID, a.jwtSecret, jwtLifetime)
+	// The plaintext is only available here, so this is where outdated hashes get upgraded.
+	// Failing to upgrade must not fail the login.
+	if a.hasher.NeedsRehash(user.PasswordHash) {
+		if passwordHash, err := a.hasher.Hash(req.Password); err != nil {
+			a.log.Errorf("failed to rehash password of user %s: %v", user.ID, err)
+		} else if err := a.storage.UpdatePasswordHash(r.Context(), user.ID, passwordHash); err != nil {
+			a.log.Errorf("failed to store rehashed password of user %s: %v", user.ID, err)
+		}
+	}
+
+	a.writeToken(w, user.ID)
+}
+
+func (a *API) Me(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middlewares.UserIDKey).(string)
+
+	user, err := a.storage.GetUserByID(r.Context(), userID)
+	if err != nil {
+		a.log.Errorf("failed to get user: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+	if user == nil {
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(user); err != nil {
+		a.log.Errorf("failed to encode user: %v", err)
+	}
+}
+
+// Notify is called by the accrual poller for a credited order. It drops the user's cached
+// balance, which no longer includes the accrual.
+func (a *API) Notify(order models.Order) {
+	a.balances.invalidate(order.UserID)
+}
+
+func (a *API) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middlewares.UserIDKey).(string)
+
+	a.balances.invalidate(userID)
+	if err := a.storage.DeleteUser(r.Context(), userID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			http.Error(w, "user not found", http.StatusUnauthorized)
+			return
+		}
+		a.log.Errorf("failed to delete user: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) writeToken(w http.ResponseWriter, userID string) {
+	token, err := auth.BuildJWTString(userID, a.keys, jwtLifetime)
 	if err != nil {
 		a.log.Errorf("failed to build JWT: %v", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -106,7 +200,11 @@ func (a *API) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Authorization", "Bearer "+token)
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(models.AuthResponse{Token: token}); err != nil {
+		a.log.Errorf("failed to encode token: %v", err)
+	}
 }
 
 func (a *API) CreateOrder(w http.ResponseWriter, r *http.Request) {
@@ -114,49 +212,182 @@ func (a *API) CreateOrder(w http.ResponseWriter, r *http.Request) {
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "failed to read request body", http.StatusInternalServerError)
+		requestBodyError(w, err, "failed to read request body")
+		return
+	}
+
+	var orderNumber string
+	if isJSONContent(r) {
+		var req models.OrderRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "invalid request format", http.StatusBadRequest)
+			return
+		}
+		orderNumber = req.Order
+	} else {
+		orderNumber = string(body)
+	}
+	orderNumber = strings.TrimSpace(orderNumber)
+
+	if orderNumber == "" {
+		http.Error(w, "order number is required", http.StatusUnprocessableEntity)
 		return
 	}
-	orderNumber := string(body)
 
 	if !luhn.IsValid(orderNumber) {
 		http.Error(w, "invalid order number format", http.StatusUnprocessableEntity)
 		return
 	}
 
-	err = a.storage.CreateOrder(r.Context(), userID, orderNumber)
+	err = a.storage.CreateOrder(r.Context(), userID, orderNumber, a.cfg.MaxOrdersPerUser)
 	if err != nil {
 		if errors.Is(err, storage.ErrOrderExists) {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 		if errors.Is(err, storage.ErrOrderExistsOther) {
+			ownerID := "unknown"
+			var ownerErr *storage.OrderOwnerError
+			if errors.As(err, &ownerErr) {
+				ownerID = ownerErr.OwnerID
+			}
+			a.ownershipConflict(userID, orderNumber, ownerID)
 			http.Error(w, "order already uploaded by another user", http.StatusConflict)
 			return
 		}
+		if errors.Is(err, storage.ErrOrderQuotaExceeded) {
+			http.Error(w, fmt.Sprintf("order quota exceeded: at most %d orders per user", a.cfg.MaxOrdersPerUser), http.StatusUnprocessableEntity)
+			return
+		}
 		a.log.Errorf("failed to create order: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		middlewares.StorageError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusAccepted)
 }
 
+func (a *API) CreateOrdersBatch(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middlewares.UserIDKey).(string)
+
+	var numbers []string
+	if err := json.NewDecoder(r.Body).Decode(&numbers); err != nil {
+		requestBodyError(w, err, "invalid request format: expected a JSON array of order numbers")
+		return
+	}
+	if len(numbers) == 0 {
+		http.Error(w, "at least one order number is required", http.StatusBadRequest)
+		return
+	}
+	if len(numbers) > maxBatchOrders {
+		http.Error(w, fmt.Sprintf("at most %d orders can be uploaded at once", maxBatchOrders), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]models.BatchOrderResult, len(numbers))
+	var valid []string
+	var validIdx []int
+	for i, number := range numbers {
+		number = strings.TrimSpace(number)
+		results[i] = models.BatchOrderResult{Order: number, Result: models.BatchOrderInvalid}
+		if luhn.IsValid(number) {
+			valid = append(valid, number)
+			validIdx = append(validIdx, i)
+		}
+	}
+
+	if len(valid) > 0 {
+		stored, err := a.storage.CreateOrdersBatch(r.Context(), userID, valid, a.cfg.MaxOrdersPerUser)
+		if err != nil {
+			a.log.Errorf("failed to create orders batch: %v", err)
+			middlewares.StorageError(w, err)
+			return
+		}
+		for j, result := range stored {
+			if result.Result == models.BatchOrderOwnedByOther {
+				a.ownershipConflict(userID, result.Order, result.OwnerID)
+			}
+			results[validIdx[j]] = result
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		a.log.Errorf("failed to encode batch results: %v", err)
+	}
+}
+
+// ownershipConflict records an upload of another user's order number, which may be fraud or a mix-up.
+func (a *API) ownershipConflict(userID, orderNumber, ownerID string) {
+	metrics.OrderOwnershipConflicts.Add(1)
+	a.log.Warnf("order %s uploaded by user %s already belongs to user %s", orderNumber, userID, ownerID)
+}
+
+func isJSONContent(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
 func (a *API) GetOrders(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middlewares.UserIDKey).(string)
 
-	orders, err := a.storage.GetOrdersByUser(r.Context(), userID)
+	format, err := parseOrdersFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+
+	if r.URL.Query().Has("updated_since") {
+		a.getOrdersUpdatedSince(w, r, userID, format)
+		return
+	}
+
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	version, err := a.storage.GetOrdersVersion(r.Context(), userID)
+	if err != nil {
+		a.log.Errorf("failed to count orders: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(version.Count))
+	if notModified(w, r, listETag(r, userID, version)) {
+		return
+	}
+
+	orders, err := a.storage.GetOrdersByUser(r.Context(), userID, filter)
 	if err != nil {
 		a.log.Errorf("failed to get orders: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		middlewares.StorageError(w, err)
 		return
 	}
+	if filter.Limit > 0 && len(orders) == filter.Limit && usesOrderCursor(filter) {
+		w.Header().Set("X-Next-Cursor", encodeOrderCursor(orders[len(orders)-1]))
+	}
 
+	a.writeOrders(w, orders, format)
+}
+
+// writeOrders answers with orders in the negotiated format, or 204 if there are none.
+func (a *API) writeOrders(w http.ResponseWriter, orders []models.Order, format string) {
 	if len(orders) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
+	if format == mediaTypeCSV {
+		if err := writeOrdersCSV(w, orders); err != nil {
+			a.log.Errorf("failed to encode orders as CSV: %v", err)
+		}
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(orders); err != nil {
@@ -164,14 +395,125 @@ func (a *API) GetOrders(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getOrdersUpdatedSince serves incremental sync: clients pass the latest updated_at they have
+// seen and receive only the orders created or changed after it.
+func (a *API) getOrdersUpdatedSince(w http.ResponseWriter, r *http.Request, userID, format string) {
+	since, err := parseUpdatedSince(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	orders, err := a.storage.GetOrdersUpdatedSince(r.Context(), userID, since)
+	if err != nil {
+		a.log.Errorf("failed to get updated orders: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+
+	a.writeOrders(w, orders, format)
+}
+
+func (a *API) GetOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middlewares.UserIDKey).(string)
+	orderNumber := chi.URLParam(r, "number")
+
+	// Orders of other users are reported as missing so their existence is not disclosed.
+	order, err := a.storage.GetUserOrder(r.Context(), userID, orderNumber)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrderNotFound) {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		a.log.Errorf("failed to get order: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(order); err != nil {
+		a.log.Errorf("failed to encode order: %v", err)
+	}
+}
+
+func (a *API) DeleteOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middlewares.UserIDKey).(string)
+	orderNumber := chi.URLParam(r, "number")
+
+	err := a.storage.DeleteOrder(r.Context(), userID, orderNumber)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrderNotFound) {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, storage.ErrOrderNotPending) {
+			http.Error(w, "order has already been processed", http.StatusConflict)
+			return
+		}
+		a.log.Errorf("failed to delete order: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) RefreshOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middlewares.UserIDKey).(string)
+	orderNumber := chi.URLParam(r, "number")
+
+	err := a.storage.RefreshOrder(r.Context(), userID, orderNumber)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrderNotFound) {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, storage.ErrOrderNotPending) {
+			http.Error(w, "order has already been processed", http.StatusConflict)
+			return
+		}
+		a.log.Errorf("failed to refresh order: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *API) GetOrderStats(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middlewares.UserIDKey).(string)
+
+	stats, err := a.storage.GetOrderStats(r.Context(), userID)
+	if err != nil {
+		a.log.Errorf("failed to get order stats: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		a.log.Errorf("failed to encode order stats: %v", err)
+	}
+}
+
 func (a *API) GetBalance(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middlewares.UserIDKey).(string)
 
 	balance, err := a.storage.GetBalance(r.Context(), userID)
 	if err != nil {
-		a.log.Errorf("failed to get balance: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
-		return
+		cached, ok := a.balances.get(userID)
+		if !ok {
+			a.log.Errorf("failed to get balance: %v", err)
+			middlewares.StorageError(w, err)
+			return
+		}
+		a.log.Warnf("failed to get balance, serving cached value: %v", err)
+		balance = cached
+		w.Header().Set("X-Cache", "stale")
+	} else {
+		a.balances.put(userID, balance)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -181,12 +523,38 @@ func (a *API) GetBalance(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (a *API) GetBalanceSummary(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middlewares.UserIDKey).(string)
+
+	summary, err := a.storage.GetBalanceSummary(r.Context(), userID)
+	if err != nil {
+		a.log.Errorf("failed to get balance summary: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		a.log.Errorf("failed to encode balance summary: %v", err)
+	}
+}
+
 func (a *API) Withdraw(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middlewares.UserIDKey).(string)
 
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "invalid dry_run: must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
 	var req models.WithdrawRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request format", http.StatusBadRequest)
+		requestBodyError(w, err, "invalid request format")
 		return
 	}
 
@@ -195,27 +563,76 @@ func (a *API) Withdraw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := a.storage.CreateWithdrawal(r.Context(), userID, req.Order, req.Sum)
+	if !isPositiveAmount(req.Sum) {
+		http.Error(w, "withdrawal sum must be a positive number", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if limit := a.cfg.MaxWithdrawal; limit > 0 && req.Sum > limit {
+		http.Error(w, fmt.Sprintf("withdrawal sum must not exceed %g", limit), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var (
+		balance *models.Balance
+		err     error
+	)
+	if dryRun {
+		balance, err = a.storage.CheckWithdrawal(r.Context(), userID, req.Sum)
+	} else {
+		// Dropped up front: even a withdrawal that fails may have been committed.
+		a.balances.invalidate(userID)
+		balance, err = a.storage.CreateWithdrawal(r.Context(), userID, req.Order, req.Sum)
+	}
 	if err != nil {
 		if errors.Is(err, storage.ErrInsufficientFunds) {
 			http.Error(w, "insufficient funds", http.StatusPaymentRequired)
 			return
 		}
+		if errors.Is(err, storage.ErrInvalidSum) {
+			http.Error(w, "withdrawal sum must be a positive number", http.StatusUnprocessableEntity)
+			return
+		}
 		a.log.Errorf("failed to create withdrawal: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		middlewares.StorageError(w, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(balance); err != nil {
+		a.log.Errorf("failed to encode balance: %v", err)
+	}
+}
+
+func isPositiveAmount(sum float64) bool {
+	return sum > 0 && !math.IsInf(sum, 0) && !math.IsNaN(sum)
 }
 
 func (a *API) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middlewares.UserIDKey).(string)
 
-	withdrawals, err := a.storage.GetWithdrawalsByUser(r.Context(), userID)
+	filter, err := parseWithdrawalFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	version, err := a.storage.GetWithdrawalsVersion(r.Context(), userID, filter)
+	if err != nil {
+		a.log.Errorf("failed to count withdrawals: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(version.Count))
+	if notModified(w, r, listETag(r, userID, version)) {
+		return
+	}
+
+	withdrawals, err := a.storage.GetWithdrawalsByUser(r.Context(), userID, filter)
 	if err != nil {
 		a.log.Errorf("failed to get withdrawals: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		middlewares.StorageError(w, err)
 		return
 	}
 
@@ -230,3 +647,40 @@ func (a *API) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 		a.log.Errorf("failed to encode withdrawals: %v", err)
 	}
 }
+
+// GetLedger returns the user's statement: accruals and withdrawals in one list, newest first.
+func (a *API) GetLedger(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middlewares.UserIDKey).(string)
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := a.storage.GetLedgerByUser(r.Context(), userID, limit, offset)
+	if err != nil {
+		a.log.Errorf("failed to get ledger: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+
+	total, err := a.storage.CountLedgerByUser(r.Context(), userID)
+	if err != nil {
+		a.log.Errorf("failed to count ledger entries: %v", err)
+		middlewares.StorageError(w, err)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	if len(entries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		a.log.Errorf("failed to encode ledger: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MarkMiraclee/gophermart/internal/middlewares"
+)
+
+func postJSON(h http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", mediaTypeJSON)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestRegisterRejectsLoginDifferingOnlyInCase(t *testing.T) {
+	api := newTestAPI(&fakeStorage{}, testConfig())
+
+	if rec := postJSON(api.Register, `{"login":"User1","password":"secret"}`); rec.Code != http.StatusOK {
+		t.Fatalf("first registration: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	for _, login := range []string{"user1", "USER1", " User1 "} {
+		rec := postJSON(api.Register, `{"login":"`+login+`","password":"other"}`)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("registering %q: status = %d, want %d", login, rec.Code, http.StatusConflict)
+		}
+	}
+}
+
+func TestLoginIgnoresCase(t *testing.T) {
+	api := newTestAPI(&fakeStorage{}, testConfig())
+	if rec := postJSON(api.Register, `{"login":"User1","password":"secret"}`); rec.Code != http.StatusOK {
+		t.Fatalf("registration: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	for _, login := range []string{"User1", "user1", "USER1", " uSeR1"} {
+		rec := postJSON(api.Login, `{"login":"`+login+`","password":"secret"}`)
+		if rec.Code != http.StatusOK {
+			t.Errorf("login as %q: status = %d, want %d", login, rec.Code, http.StatusOK)
+		}
+		if rec.Header().Get("Authorization") == "" {
+			t.Errorf("login as %q: no token issued", login)
+		}
+	}
+	if rec := postJSON(api.Login, `{"login":"user1","password":"wrong"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCreateOrderRejectsUnicodeDigits(t *testing.T) {
+	api := newTestAPI(&fakeStorage{}, testConfig())
+
+	for _, number := range []string{"１２３４５６７８９０３", "١٢٣٤٥٦٧٨٩٠٣", "1234567890３"} {
+		for _, body := range []string{number, `{"order":"` + number + `"}`} {
+			req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader(body))
+			if strings.HasPrefix(body, "{") {
+				req.Header.Set("Content-Type", mediaTypeJSON)
+			} else {
+				req.Header.Set("Content-Type", "text/plain")
+			}
+			req = req.WithContext(context.WithValue(req.Context(), middlewares.UserIDKey, "user"))
+			rec := httptest.NewRecorder()
+			api.CreateOrder(rec, req)
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Errorf("uploading %s: status = %d, want %d", body, rec.Code, http.StatusUnprocessableEntity)
+			}
+		}
+	}
+}
+
+func TestPasswordByteLimit(t *testing.T) {
+	api := newTestAPI(&fakeStorage{}, testConfig())
+	atLimit := strings.Repeat("p", maxPasswordBytes)
+
+	if rec := postJSON(api.Register, `{"login":"long","password":"`+atLimit+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("registering with %d bytes: status = %d, want %d", maxPasswordBytes, rec.Code, http.StatusOK)
+	}
+	if rec := postJSON(api.Register, `{"login":"longer","password":"`+atLimit+`x"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("registering with %d bytes: status = %d, want %d", maxPasswordBytes+1, rec.Code, http.StatusBadRequest)
+	}
+	if rec := postJSON(api.Login, `{"login":"long","password":"`+atLimit+`"}`); rec.Code != http.StatusOK {
+		t.Errorf("login with %d bytes: status = %d, want %d", maxPasswordBytes, rec.Code, http.StatusOK)
+	}
+	// bcrypt alone would accept this, comparing only the first 72 bytes.
+	if rec := postJSON(api.Login, `{"login":"long","password":"`+atLimit+`x"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("login with %d bytes: status = %d, want %d", maxPasswordBytes+1, rec.Code, http.StatusUnauthorized)
+	}
+}
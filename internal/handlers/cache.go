@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MarkMiraclee/gophermart/internal/models"
+)
+
+// balanceCache remembers the last balance read for each user so that GetBalance can answer with
+// a slightly stale value while the database is briefly unavailable. It is never read while the
+// database answers. A zero ttl disables it.
+type balanceCache struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	entries   map[string]cachedBalance
+	lastSweep time.Time
+}
+
+type cachedBalance struct {
+	balance  models.Balance
+	storedAt time.Time
+}
+
+func newBalanceCache(ttl time.Duration) *balanceCache {
+	return &balanceCache{ttl: ttl, entries: make(map[string]cachedBalance)}
+}
+
+func (c *balanceCache) get(userID string) (*models.Balance, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[userID]
+	if !ok || time.Since(entry.storedAt) > c.ttl {
+		return nil, false
+	}
+	balance := entry.balance
+	return &balance, true
+}
+
+func (c *balanceCache) put(userID string, balance *models.Balance) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// Expired entries are useless, so drop them now and then instead of letting the map grow.
+	if now.Sub(c.lastSweep) > c.ttl {
+		for id, entry := range c.entries {
+			if now.Sub(entry.storedAt) > c.ttl {
+				delete(c.entries, id)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[userID] = cachedBalance{balance: *balance, storedAt: now}
+}
+
+func (c *balanceCache) invalidate(userID string) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, userID)
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MarkMiraclee/gophermart/internal/models"
+)
+
+func TestNotifyInvalidatesCachedBalance(t *testing.T) {
+	cfg := testConfig()
+	cfg.BalanceCacheTTL = time.Minute
+	api := newTestAPI(&fakeStorage{}, cfg)
+
+	api.balances.put("user", &models.Balance{Current: 100})
+	api.balances.put("other", &models.Balance{Current: 50})
+	api.Notify(models.Order{UserID: "user", Number: "12345678903", Status: models.OrderStatusProcessed})
+
+	if _, ok := api.balances.get("user"); ok {
+		t.Error("balance of the credited user is still cached")
+	}
+	if _, ok := api.balances.get("other"); !ok {
+		t.Error("balance of another user was dropped")
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MarkMiraclee/gophermart/internal/models"
+)
+
+const (
+	mediaTypeJSON = "application/json"
+	mediaTypeCSV  = "text/csv"
+)
+
+var orderCSVHeader = []string{"number", "status", "accrual", "uploaded_at"}
+
+// csvFlushRows is how many rows are sent at a time, so that large exports reach the client
+// while they are written instead of piling up in buffers.
+const csvFlushRows = 100
+
+// writeOrdersCSV streams orders as a CSV download; orders without an accrual leave the column empty.
+func writeOrdersCSV(w http.ResponseWriter, orders []models.Order) error {
+	w.Header().Set("Content-Type", mediaTypeCSV+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="orders.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	cw := csv.NewWriter(w)
+	if err := cw.Write(orderCSVHeader); err != nil {
+		return err
+	}
+	for i, order := range orders {
+		if i > 0 && i%csvFlushRows == 0 {
+			if err := flushCSV(cw, rc); err != nil {
+				return err
+			}
+		}
+		accrual := ""
+		if order.Accrual != nil {
+			accrual = strconv.FormatFloat(*order.Accrual, 'f', -1, 64)
+		}
+		record := []string{order.Number, string(order.Status), accrual, order.UploadedAt.Format(time.RFC3339)}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	return flushCSV(cw, rc)
+}
+
+// flushCSV sends the buffered rows to the client. Writers in between that cannot flush only
+// delay the rows, so that is not an error.
+func flushCSV(cw *csv.Writer, rc *http.ResponseController) error {
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MarkMiraclee/gophermart/internal/models"
+)
+
+func TestOrdersCSVIsStreamed(t *testing.T) {
+	uploaded := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	orders := make([]models.Order, 3*csvFlushRows+1)
+	for i := range orders {
+		orders[i] = models.Order{Number: strconv.Itoa(1000 + i), Status: models.OrderStatusNew, UploadedAt: uploaded, UpdatedAt: uploaded}
+	}
+	s := &fakeStorage{users: []*models.User{{ID: "user"}}, orders: orders}
+	router := NewRouter(newTestAPI(s, testConfig()))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken("user"))
+	req.Header.Set("Accept", mediaTypeCSV)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	// http.TimeoutHandler would have buffered the export and hidden the flushes.
+	if !rec.Flushed {
+		t.Error("CSV export was not flushed")
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(orders)+1 {
+		t.Fatalf("got %d records, want a header and %d rows", len(records), len(orders))
+	}
+	if last := records[len(records)-1]; last[0] != orders[len(orders)-1].Number {
+		t.Errorf("last row = %v, want order %s", last, orders[len(orders)-1].Number)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// requestBodyError answers a request whose body could not be read or decoded: 413 when it
+// exceeded MAX_BODY_SIZE, which without a Content-Length is only noticed while reading, and 400
+// with message for anything else, such as malformed JSON or gzip.
+func requestBodyError(w http.ResponseWriter, err error, message string) {
+	var sizeErr *http.MaxBytesError
+	if errors.As(err, &sizeErr) {
+		http.Error(w, fmt.Sprintf("request body must not exceed %d bytes", sizeErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, message, http.StatusBadRequest)
+}
+
+// decodeStrictJSON decodes a single JSON object into dst, rejecting unknown fields,
+// and returns an error whose message is safe and specific enough to show to clients.
+// A body over the size limit is reported as the *http.MaxBytesError itself.
+func decodeStrictJSON(body io.Reader, dst any) error {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil {
+		if dec.More() {
+			return errors.New("request body must contain a single JSON object")
+		}
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var sizeErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &sizeErr):
+		return sizeErr
+	case errors.Is(err, io.EOF):
+		return errors.New("request body is empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("request body contains malformed JSON")
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("request body contains malformed JSON at position %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("field %q must be of type %s", typeErr.Field, typeErr.Type)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return fmt.Errorf("request body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	default:
+		return errors.New("invalid request format")
+	}
+}
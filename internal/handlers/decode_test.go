@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MarkMiraclee/gophermart/internal/models"
+)
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGzippedBodyOverLimit(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxBodySize = 256
+	s := &fakeStorage{users: []*models.User{{ID: "user"}}}
+	router := NewRouter(newTestAPI(s, cfg))
+
+	// Compressed, each body is far below the limit, and it declares no decompressed length.
+	large := strings.Repeat("1", 4*int(cfg.MaxBodySize))
+	tests := []struct {
+		name, path, contentType, body string
+		auth                          bool
+	}{
+		{"order", "/api/user/orders", "text/plain", large, true},
+		{"batch", "/api/user/orders/batch", mediaTypeJSON, `["` + large + `"]`, true},
+		{"withdraw", "/api/user/balance/withdraw", mediaTypeJSON, `{"order":"` + large + `","sum":1}`, true},
+		{"register", "/api/user/register", mediaTypeJSON, `{"login":"` + large + `","password":"p"}`, false},
+		{"login", "/api/user/login", mediaTypeJSON, `{"login":"` + large + `","password":"p"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := gzipped(t, tt.body)
+			if int64(len(body)) >= int64(cfg.MaxBodySize) {
+				t.Fatalf("compressed body of %d bytes is not below the limit", len(body))
+			}
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Content-Encoding", "gzip")
+			if tt.auth {
+				req.Header.Set("Authorization", "Bearer "+testToken("user"))
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusRequestEntityTooLarge, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCorruptGzipBodyIsBadRequest(t *testing.T) {
+	s := &fakeStorage{users: []*models.User{{ID: "user"}}}
+	router := NewRouter(newTestAPI(s, testConfig()))
+
+	body := gzipped(t, "12345678903")
+	// Keep the gzip header intact so that only reading the body fails.
+	corrupt := append(body[:12:12], bytes.Repeat([]byte{0xff}, 16)...)
+	req := httptest.NewRequest(http.MethodPost, "/api/user/orders", bytes.NewReader(corrupt))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Authorization", "Bearer "+testToken("user"))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
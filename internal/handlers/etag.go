@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/MarkMiraclee/gophermart/internal/models"
+)
+
+// listETag derives a weak ETag for one page of a listing. The query and Accept header are part
+// of it because the same data sorted, paged or encoded differently is a different response.
+func listETag(r *http.Request, userID string, version *models.ListVersion) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s|%d|%d",
+		r.URL.Path, r.URL.RawQuery, r.Header.Get("Accept"), userID, version.Count, version.LastModified.UnixNano())))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified sets the ETag and answers 304 if the client already has this version.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches implements the weak comparison If-None-Match requires (RFC 9110, section 13.1.2).
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
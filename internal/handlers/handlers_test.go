@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/MarkMiraclee/gophermart/internal/auth"
+	"github.com/MarkMiraclee/gophermart/internal/config"
+	"github.com/MarkMiraclee/gophermart/internal/models"
+	"github.com/MarkMiraclee/gophermart/internal/storage"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const testSecret = "test-secret"
+
+func testConfig() *config.Config {
+	return &config.Config{
+		JWTSecret:      testSecret,
+		JWTLeeway:      config.DefaultJWTLeeway,
+		PasswordHasher: config.DefaultPasswordHasher,
+		BcryptCost:     4,
+		RequestTimeout: config.DefaultRequestTimeout,
+		MaxBodySize:    config.DefaultMaxBodySize,
+		GzipLevel:      config.DefaultGzipLevel,
+		GzipMinSize:    config.DefaultGzipMinSize,
+		UserRateLimit:  1000,
+		UserRateBurst:  1000,
+	}
+}
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func newTestAPI(s storage.Storage, cfg *config.Config) *API {
+	return NewAPI(s, testLogger(), cfg, auth.BcryptHasher{Cost: cfg.BcryptCost})
+}
+
+func testToken(userID string) string {
+	token, err := auth.BuildJWTString(userID, auth.NewKeyring([]string{testSecret}), time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
+
+// fakeStorage keeps users in memory; methods the tests do not need panic through the nil
+// embedded interface.
+type fakeStorage struct {
+	storage.Storage
+	users  []*models.User
+	orders []models.Order
+	// When release is set, user lookups signal entered, if it has room, and then wait for release.
+	entered, release chan struct{}
+}
+
+func (s *fakeStorage) Ready(context.Context) error {
+	return nil
+}
+
+func (s *fakeStorage) GetUserByID(_ context.Context, id string) (*models.User, error) {
+	if s.release != nil {
+		select {
+		case s.entered <- struct{}{}:
+		default:
+		}
+		<-s.release
+	}
+	for _, user := range s.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateUser enforces unique logins regardless of case, like the users_login_lower_key index.
+func (s *fakeStorage) CreateUser(_ context.Context, login, passwordHash string) (*models.User, error) {
+	if user, _ := s.GetUserByLogin(context.Background(), login); user != nil {
+		return nil, storage.ErrLoginExists
+	}
+	user := &models.User{ID: uuid.NewString(), Login: login, PasswordHash: passwordHash, CreatedAt: time.Now()}
+	s.users = append(s.users, user)
+	return user, nil
+}
+
+func (s *fakeStorage) GetUserByLogin(_ context.Context, login string) (*models.User, error) {
+	for _, user := range s.users {
+		if strings.ToLower(user.Login) == strings.ToLower(login) {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeStorage) GetOrdersVersion(_ context.Context, _ string) (*models.ListVersion, error) {
+	version := &models.ListVersion{Count: len(s.orders)}
+	for _, order := range s.orders {
+		if order.UpdatedAt.After(version.LastModified) {
+			version.LastModified = order.UpdatedAt
+		}
+	}
+	return version, nil
+}
+
+func (s *fakeStorage) GetOrdersByUser(_ context.Context, _ string, _ models.OrderFilter) ([]models.Order, error) {
+	return s.orders, nil
+}
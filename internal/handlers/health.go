@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// Live reports that the process is serving requests; it never touches dependencies.
+func (a *API) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// Ready reports whether the database is reachable and migrated, so traffic can be routed here.
+func (a *API) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	if err := a.storage.Ready(ctx); err != nil {
+		a.log.Warnf("readiness check failed: %v", err)
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MarkMiraclee/gophermart/internal/middlewares"
+	"github.com/MarkMiraclee/gophermart/internal/models"
+)
+
+func parseOrderFilter(r *http.Request) (models.OrderFilter, error) {
+	var filter models.OrderFilter
+
+	switch sortBy := models.OrderSortField(r.URL.Query().Get("sort")); sortBy {
+	case "":
+	case models.OrderSortUploadedAt, models.OrderSortStatus, models.OrderSortAccrual:
+		filter.SortBy = sortBy
+	default:
+		return filter, errors.New("invalid sort: must be one of uploaded_at, status, accrual")
+	}
+
+	switch order := r.URL.Query().Get("order"); order {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		return filter, errors.New("invalid order: must be asc or desc")
+	}
+
+	var err error
+	if filter.Limit, filter.Offset, err = parsePagination(r); err != nil {
+		return filter, err
+	}
+
+	if after := r.URL.Query().Get("after"); after != "" {
+		if (filter.SortBy != "" && filter.SortBy != models.OrderSortUploadedAt) || filter.Ascending {
+			return filter, errors.New("invalid after: cursors only work with the default sort")
+		}
+		if filter.Offset > 0 {
+			return filter, errors.New("invalid after: cannot be combined with offset")
+		}
+		if filter.After, err = decodeOrderCursor(after); err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
+
+// parseUpdatedSince reads updated_since, which returns every matching order in change order
+// and so cannot be combined with the sorting and paging parameters.
+func parseUpdatedSince(r *http.Request) (time.Time, error) {
+	query := r.URL.Query()
+	for _, name := range []string{"sort", "order", "limit", "offset", "after"} {
+		if query.Has(name) {
+			return time.Time{}, fmt.Errorf("invalid updated_since: cannot be combined with %s", name)
+		}
+	}
+	since, err := time.Parse(time.RFC3339Nano, query.Get("updated_since"))
+	if err != nil {
+		return time.Time{}, errors.New("invalid updated_since: must be an RFC3339 timestamp")
+	}
+	return since, nil
+}
+
+// usesOrderCursor reports whether the next page of a listing can be addressed by cursor.
+func usesOrderCursor(filter models.OrderFilter) bool {
+	return (filter.SortBy == "" || filter.SortBy == models.OrderSortUploadedAt) && !filter.Ascending && filter.Offset == 0
+}
+
+// encodeOrderCursor makes an opaque cursor out of the keyset of order.
+func encodeOrderCursor(order models.Order) string {
+	raw := order.UploadedAt.UTC().Format(time.RFC3339Nano) + "|" + order.Number
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeOrderCursor(cursor string) (*models.OrderCursor, error) {
+	errInvalid := errors.New("invalid after: malformed cursor")
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalid
+	}
+	uploadedAt, number, ok := strings.Cut(string(raw), "|")
+	if !ok || number == "" {
+		return nil, errInvalid
+	}
+	t, err := time.Parse(time.RFC3339Nano, uploadedAt)
+	if err != nil {
+		return nil, errInvalid
+	}
+	return &models.OrderCursor{UploadedAt: t, Number: number}, nil
+}
+
+func parseWithdrawalFilter(r *http.Request) (models.WithdrawalFilter, error) {
+	var filter models.WithdrawalFilter
+	var err error
+
+	if filter.From, err = parseTimeParam(r, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseTimeParam(r, "to"); err != nil {
+		return filter, err
+	}
+	if filter.Limit, filter.Offset, err = parsePagination(r); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func parseTimeParam(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: must be an RFC3339 timestamp", name)
+	}
+	return &t, nil
+}
+
+func parsePagination(r *http.Request) (limit, offset int, err error) {
+	if limit, err = parseNonNegativeInt(r, "limit"); err != nil {
+		return 0, 0, err
+	}
+	if offset, err = parseNonNegativeInt(r, "offset"); err != nil {
+		return 0, 0, err
+	}
+	return limit, offset, nil
+}
+
+func parseNonNegativeInt(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// parseOrdersFormat picks the media type of an orders listing: an explicit format parameter
+// wins over the Accept header, which the router has already checked to allow one of them.
+func parseOrdersFormat(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+		if mediaType := middlewares.Negotiate(r, mediaTypeJSON, mediaTypeCSV); mediaType != "" {
+			return mediaType, nil
+		}
+		return mediaTypeJSON, nil
+	case "json":
+		return mediaTypeJSON, nil
+	case "csv":
+		return mediaTypeCSV, nil
+	default:
+		return "", errors.New("invalid format: must be json or csv")
+	}
+}
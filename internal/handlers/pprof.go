@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// NewPprofRouter serves net/http/pprof under /debug/pprof/. It is meant for its own listener,
+// never for the API router.
+func NewPprofRouter() *chi.Mux {
+	r := chi.NewRouter()
+	mountPprof(r)
+	return r
+}
+
+func mountPprof(r chi.Router) {
+	r.Mount("/debug", middleware.Profiler())
+}
@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"net/http"
+	"time"
+
+	"github.com/MarkMiraclee/gophermart/internal/metrics"
 	"github.com/MarkMiraclee/gophermart/internal/middlewares"
 	"github.com/go-chi/chi/v5"
 )
@@ -8,21 +12,119 @@ import (
 func NewRouter(api *API) *chi.Mux {
 	r := chi.NewRouter()
 
+	var users middlewares.UserLookup
+	if api.cfg.AuthVerifyUser {
+		users = api.storage
+	}
+
 	r.Use(middlewares.Logger(api.log))
-	r.Use(middlewares.Gzip(api.log))
-
-	r.Route("/api/user", func(r chi.Router) {
-		r.Post("/register", api.Register)
-		r.Post("/login", api.Login)
-
-		r.Group(func(r chi.Router) {
-			r.Use(middlewares.Auth(api.jwtSecret))
-			r.Post("/orders", api.CreateOrder)
-			r.Get("/orders", api.GetOrders)
-			r.Get("/balance", api.GetBalance)
-			r.Post("/balance/withdraw", api.Withdraw)
-			r.Get("/withdrawals", api.GetWithdrawals)
+	r.Use(middlewares.CORS(api.cfg.CORSAllowedOrigins))
+	r.Use(middlewares.Gzip(api.log, api.cfg.GzipLevel, int(api.cfg.GzipMinSize)))
+	r.Use(middlewares.BodyLimit(int64(api.cfg.MaxBodySize)))
+
+	// The timeout is applied per route rather than globally: http.TimeoutHandler buffers the whole
+	// response and cannot flush it, which would defeat streamed responses.
+	timeout := middlewares.Timeout(api.cfg.RequestTimeout)
+
+	// Probes must answer while the server is saturated, or a busy instance would be restarted or
+	// taken out of rotation; they are cheap, so they bypass the concurrency limit.
+	r.With(timeout).Get("/healthz/live", api.Live)
+	r.With(timeout).Get("/healthz/ready", api.Ready)
+
+	r.Group(func(r chi.Router) {
+		r.Use(middlewares.ConcurrencyLimit(api.cfg.MaxInFlight))
+
+		// Routes answering with a body only produce JSON; others may be called with any Accept header.
+		acceptJSON := middlewares.Accept(mediaTypeJSON)
+
+		r.Route("/api/user", func(r chi.Router) {
+			r.With(timeout, acceptJSON).Post("/register", api.Register)
+			r.With(timeout, acceptJSON).Post("/login", api.Login)
+
+			r.Group(func(r chi.Router) {
+				r.Use(middlewares.Auth(api.keys, api.cfg.JWTLeeway, users, api.log))
+				r.Use(middlewares.RateLimit(api.cfg.UserRateLimit, api.cfg.UserRateBurst))
+				r.With(ordersTimeout(api.cfg.RequestTimeout), middlewares.Accept(mediaTypeJSON, mediaTypeCSV)).
+					Get("/orders", api.GetOrders)
+
+				r.Group(func(r chi.Router) {
+					r.Use(timeout)
+					r.With(acceptJSON).Get("/me", api.Me)
+					r.Delete("/", api.DeleteUser)
+					r.Post("/orders", api.CreateOrder)
+					r.With(acceptJSON).Post("/orders/batch", api.CreateOrdersBatch)
+					r.With(acceptJSON).Get("/orders/stats", api.GetOrderStats)
+					r.With(acceptJSON).Get("/orders/{number}", api.GetOrder)
+					r.Delete("/orders/{number}", api.DeleteOrder)
+					r.Post("/orders/{number}/refresh", api.RefreshOrder)
+					r.With(acceptJSON).Get("/balance", api.GetBalance)
+					r.With(acceptJSON).Get("/balance/summary", api.GetBalanceSummary)
+					r.With(acceptJSON).Post("/balance/withdraw", api.Withdraw)
+					r.With(acceptJSON).Get("/withdrawals", api.GetWithdrawals)
+					r.With(acceptJSON).Get("/ledger", api.GetLedger)
+				})
+			})
 		})
+
+		// Without an internal listener the operational routes stay on the public one, but all of them
+		// behind admin authentication: an open /metrics is only served on INTERNAL_ADDRESS.
+		if api.cfg.InternalAddress == "" {
+			r.Group(func(r chi.Router) {
+				r.Use(timeout)
+				mountOperational(r, api, true)
+			})
+		}
 	})
 	return r
 }
+
+// ordersTimeout limits JSON order lists like every other route, but only puts a deadline on the
+// context of CSV exports so that they can be streamed; writing them is bounded by
+// SERVER_WRITE_TIMEOUT.
+func ordersTimeout(d time.Duration) func(http.Handler) http.Handler {
+	timeout, deadline := middlewares.Timeout(d), middlewares.Deadline(d)
+	return func(next http.Handler) http.Handler {
+		limited, streamed := timeout(next), deadline(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if format, err := parseOrdersFormat(r); err == nil && format == mediaTypeCSV {
+				streamed.ServeHTTP(w, r)
+				return
+			}
+			limited.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NewInternalRouter serves the operational routes on INTERNAL_ADDRESS, without CORS since no
+// browser should reach it and without the request timeout so CPU profiles can run in full.
+func NewInternalRouter(api *API) *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(middlewares.Logger(api.log))
+
+	mountOperational(r, api, false)
+	if api.cfg.PprofEnabled {
+		mountPprof(r)
+	}
+	return r
+}
+
+// mountOperational adds the metrics and admin routes; adminMetrics puts /metrics behind admin
+// authentication as well.
+func mountOperational(r chi.Router, api *API, adminMetrics bool) {
+	var users middlewares.UserLookup
+	if api.cfg.AuthVerifyUser {
+		users = api.storage
+	}
+	admin := chi.Chain(middlewares.Auth(api.keys, api.cfg.JWTLeeway, users, api.log), middlewares.Admin(api.storage))
+
+	if adminMetrics {
+		r.With(admin...).Get("/metrics", metrics.Handler().ServeHTTP)
+	} else {
+		r.Get("/metrics", metrics.Handler().ServeHTTP)
+	}
+
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(admin...)
+		r.With(middlewares.Accept(mediaTypeJSON)).Get("/users", api.ListUsers)
+	})
+}
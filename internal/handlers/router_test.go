@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MarkMiraclee/gophermart/internal/models"
+)
+
+func TestMetricsWithoutInternalAddressRequireAdmin(t *testing.T) {
+	s := &fakeStorage{users: []*models.User{{ID: "user"}, {ID: "admin", IsAdmin: true}}}
+	router := NewRouter(newTestAPI(s, testConfig()))
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"anonymous", "", http.StatusUnauthorized},
+		{"user", testToken("user"), http.StatusForbidden},
+		{"admin", testToken("admin"), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestInternalRouterServesMetricsOpenly(t *testing.T) {
+	cfg := testConfig()
+	cfg.InternalAddress = "localhost:9090"
+	api := newTestAPI(&fakeStorage{}, cfg)
+
+	rec := httptest.NewRecorder()
+	NewInternalRouter(api).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("internal /metrics status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = httptest.NewRecorder()
+	NewRouter(api).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("public /metrics status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestHealthProbesBypassConcurrencyLimit(t *testing.T) {
+	s := &fakeStorage{users: []*models.User{{ID: "user"}}, entered: make(chan struct{}, 1), release: make(chan struct{})}
+	cfg := testConfig()
+	cfg.MaxInFlight = 1
+	cfg.AuthVerifyUser = true
+	router := NewRouter(newTestAPI(s, cfg))
+
+	// Take the only slot with a request that blocks in its user lookup.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodGet, "/api/user/me", nil)
+		req.Header.Set("Authorization", "Bearer "+testToken("user"))
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-s.entered
+	defer func() {
+		close(s.release)
+		<-done
+	}()
+
+	for path, want := range map[string]int{
+		"/healthz/live":     http.StatusOK,
+		"/healthz/ready":    http.StatusOK,
+		"/api/user/balance": http.StatusServiceUnavailable,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s while saturated: status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
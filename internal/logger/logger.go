@@ -0,0 +1,16 @@
+package logger
+
+import "github.com/sirupsen/logrus"
+
+// Logger is the subset of logging methods the application relies on.
+// *logrus.Logger satisfies it, and tests can substitute a spy implementation.
+type Logger interface {
+	Info(args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	WithFields(fields logrus.Fields) *logrus.Entry
+	IsLevelEnabled(level logrus.Level) bool
+}
+
+var _ Logger = (*logrus.Logger)(nil)
@@ -0,0 +1,83 @@
+package logger
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	// bearerPattern matches token credentials as sent in an Authorization header value.
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer)\s+[A-Za-z0-9\-._~+/]{16,}=*`)
+	// jwtPattern matches compact JWTs wherever they appear, e.g. inside an error message.
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`)
+)
+
+// sensitiveHeaders are never logged with their values.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Sanitizer is a logrus hook that masks configured secrets, bearer tokens and JWTs in the
+// message and every string-like field of an entry before it is written.
+type Sanitizer struct {
+	secrets []string
+}
+
+// NewSanitizer masks the given secrets; empty values are ignored.
+func NewSanitizer(secrets ...string) *Sanitizer {
+	s := &Sanitizer{}
+	for _, secret := range secrets {
+		if secret != "" {
+			s.secrets = append(s.secrets, secret)
+		}
+	}
+	return s
+}
+
+func (s *Sanitizer) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (s *Sanitizer) Fire(entry *logrus.Entry) error {
+	entry.Message = s.Sanitize(entry.Message)
+	for key, value := range entry.Data {
+		if strings.EqualFold(key, "authorization") {
+			entry.Data[key] = redacted
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = s.Sanitize(v)
+		case error:
+			entry.Data[key] = s.Sanitize(v.Error())
+		case fmt.Stringer:
+			entry.Data[key] = s.Sanitize(v.String())
+		case http.Header:
+			entry.Data[key] = SanitizeHeaders(v)
+		}
+	}
+	return nil
+}
+
+func (s *Sanitizer) Sanitize(text string) string {
+	for _, secret := range s.secrets {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	text = bearerPattern.ReplaceAllString(text, "$1 "+redacted)
+	return jwtPattern.ReplaceAllString(text, redacted)
+}
+
+// SanitizeHeaders returns a copy of h that is safe to log.
+func SanitizeHeaders(h http.Header) http.Header {
+	sanitized := h.Clone()
+	for _, name := range sensitiveHeaders {
+		if _, ok := sanitized[name]; ok {
+			sanitized[name] = []string{redacted}
+		}
+	}
+	return sanitized
+}
@@ -1,17 +1,25 @@
 package luhn
 
-import "strconv"
+import "math/rand/v2"
 
+// IsValid reports whether number is a non-empty string of ASCII digits that passes the Luhn checksum.
 func IsValid(number string) bool {
+	if number == "" {
+		return false
+	}
+
 	var sum int
 	nDigits := len(number)
 	parity := nDigits % 2
 
-	for i, d := range number {
-		digit, err := strconv.Atoi(string(d))
-		if err != nil {
+	// Iterate over bytes rather than runes so that i is always the digit position;
+	// any byte of a multi-byte rune falls outside '0'..'9' and is rejected.
+	for i := 0; i < nDigits; i++ {
+		d := number[i]
+		if d < '0' || d > '9' {
 			return false
 		}
+		digit := int(d - '0')
 
 		if i%2 == parity {
 			digit *= 2
@@ -23,3 +31,39 @@ func IsValid(number string) bool {
 	}
 	return sum%10 == 0
 }
+
+// AppendCheckDigit returns prefix followed by the digit that makes it Luhn-valid.
+// It returns an empty string if prefix contains anything but ASCII digits.
+func AppendCheckDigit(prefix string) string {
+	var sum int
+	for i := len(prefix) - 1; i >= 0; i-- {
+		d := prefix[i]
+		if d < '0' || d > '9' {
+			return ""
+		}
+		digit := int(d - '0')
+
+		// The rightmost prefix digit sits next to the check digit, so it is doubled.
+		if (len(prefix)-1-i)%2 == 0 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return prefix + string(rune('0'+(10-sum%10)%10))
+}
+
+// Generate returns a random Luhn-valid number of the given length, or an empty string if length < 1.
+func Generate(length int) string {
+	if length < 1 {
+		return ""
+	}
+
+	prefix := make([]byte, length-1)
+	for i := range prefix {
+		prefix[i] = byte('0' + rand.IntN(10))
+	}
+	return AppendCheckDigit(string(prefix))
+}
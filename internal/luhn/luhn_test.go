@@ -0,0 +1,92 @@
+package luhn
+
+import "testing"
+
+func TestIsValid(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"0", true},
+		{"18", true},
+		{"79927398713", true},
+		{"12345678903", true},
+		{"4561261212345467", true},
+		{"79927398710", false},
+		{"12345678901", false},
+		{"4561261212345464", false},
+		{"", false},
+		{"1234a678903", false},
+		{"-18", false},
+		{"18 ", false},
+		{" 18", false},
+		{"1 8", false},
+		{"+18", false},
+		{"1.8", false},
+	}
+	for _, tt := range tests {
+		if got := IsValid(tt.number); got != tt.want {
+			t.Errorf("IsValid(%q) = %t, want %t", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestIsValidRejectsNonASCIIDigits(t *testing.T) {
+	// Each of these reads as a valid number, e.g. 18 or 79927398713, in another script.
+	for _, number := range []string{
+		"１８",          // full-width
+		"١٨",          // Arabic-Indic
+		"۱۸",          // extended Arabic-Indic
+		"१८",          // Devanagari
+		"7992739871３", // one full-width digit
+		"𝟏𝟖",          // mathematical bold
+	} {
+		if IsValid(number) {
+			t.Errorf("IsValid(%q) = true, want false", number)
+		}
+	}
+}
+
+func TestGenerateIsValid(t *testing.T) {
+	for length := 1; length <= 32; length++ {
+		for i := 0; i < 200; i++ {
+			number := Generate(length)
+			if len(number) != length {
+				t.Fatalf("Generate(%d) = %q, wrong length", length, number)
+			}
+			if !IsValid(number) {
+				t.Fatalf("Generate(%d) = %q, which IsValid rejects", length, number)
+			}
+		}
+	}
+	if got := Generate(0); got != "" {
+		t.Errorf("Generate(0) = %q, want empty", got)
+	}
+}
+
+func TestAppendCheckDigit(t *testing.T) {
+	tests := []struct{ prefix, want string }{
+		{"", "0"},
+		{"1", "18"},
+		{"7992739871", "79927398713"},
+		{"1234567890", "12345678903"},
+		{"12a", ""},
+	}
+	for _, tt := range tests {
+		if got := AppendCheckDigit(tt.prefix); got != tt.want {
+			t.Errorf("AppendCheckDigit(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+	// Exactly one check digit completes any prefix.
+	for _, prefix := range []string{"0", "42", "4561261212345", "99999999999"} {
+		valid := 0
+		for d := '0'; d <= '9'; d++ {
+			if IsValid(prefix + string(d)) {
+				valid++
+			}
+		}
+		if valid != 1 {
+			t.Errorf("%d check digits complete %q, want 1", valid, prefix)
+		}
+	}
+}
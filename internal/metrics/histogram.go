@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Histogram counts durations into cumulative buckets, Prometheus style: each bucket holds the
+// observations less than or equal to its upper bound, and "+Inf" holds all of them.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []time.Duration
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+}
+
+// NewHistogram publishes a histogram under name; bounds must be ascending.
+func NewHistogram(name string, bounds ...time.Duration) *Histogram {
+	h := &Histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+	vars.Set(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if d <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += d
+}
+
+// String implements expvar.Var.
+func (h *Histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := make(map[string]uint64, len(h.bounds)+1)
+	for i, bound := range h.bounds {
+		buckets[strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)] = h.counts[i]
+	}
+	buckets["+Inf"] = h.count
+
+	data, _ := json.Marshal(struct {
+		Buckets map[string]uint64 `json:"buckets"`
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+	}{buckets, h.count, h.sum.Seconds()})
+	return string(data)
+}
@@ -0,0 +1,44 @@
+// Package metrics publishes operational counters and gauges as expvar variables.
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+	"time"
+)
+
+// vars holds this package's variables only. They are kept out of the global expvar registry,
+// whose cmdline entry would reveal the secrets passed as flags and whose memstats are no
+// business of a scraper.
+var vars expvar.Map
+
+var (
+	// AccrualOldestPendingAge is the age in seconds of the oldest NEW or PROCESSING order, 0 when there is none.
+	AccrualOldestPendingAge = newFloat("accrual_oldest_pending_order_age_seconds")
+	// AccrualProcessingTime is the time from upload until an order became PROCESSED.
+	AccrualProcessingTime = NewHistogram("accrual_processing_seconds",
+		time.Second, 10*time.Second, time.Minute, 5*time.Minute, 15*time.Minute, time.Hour, 6*time.Hour, 24*time.Hour)
+	// OrderOwnershipConflicts counts uploads of order numbers that belong to another user.
+	OrderOwnershipConflicts = newInt("order_ownership_conflicts_total")
+)
+
+func newFloat(name string) *expvar.Float {
+	v := new(expvar.Float)
+	vars.Set(name, v)
+	return v
+}
+
+func newInt(name string) *expvar.Int {
+	v := new(expvar.Int)
+	vars.Set(name, v)
+	return v
+}
+
+// Handler serves the variables of this package as one JSON object.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(vars.String()))
+	})
+}
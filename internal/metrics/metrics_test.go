@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerServesOnlyOwnVariables(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("body is not a JSON object: %v", err)
+	}
+	for _, name := range []string{"cmdline", "memstats"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("%s must not be served", name)
+		}
+	}
+	for _, name := range []string{
+		"accrual_oldest_pending_order_age_seconds", "accrual_processing_seconds", "order_ownership_conflicts_total",
+	} {
+		if _, ok := got[name]; !ok {
+			t.Errorf("%s is missing", name)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package middlewares
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Accept answers 406 Not Acceptable when the request's Accept header rules out every one of
+// the offered media types. A missing Accept header accepts anything.
+func Accept(offers ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if negotiate(r.Header.Get("Accept"), offers) == "" {
+				http.Error(w, "not acceptable: supported types are "+strings.Join(offers, ", "), http.StatusNotAcceptable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// negotiate picks the offer the Accept header prefers, by quality and then by the order of
+// offers, or returns "" if none is acceptable. Malformed entries are ignored, and a header
+// without any usable entry accepts the first offer.
+func negotiate(accept string, offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	type mediaRange struct {
+		typ, subtype string
+		q            float64
+	}
+	var ranges []mediaRange
+	for _, entry := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err != nil {
+			continue
+		}
+		typ, subtype, ok := strings.Cut(mediaType, "/")
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q})
+	}
+	if len(ranges) == 0 {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		typ, subtype, _ := strings.Cut(offer, "/")
+		// The most specific matching range decides the offer's quality.
+		q, specificity := 0.0, -1
+		for _, rng := range ranges {
+			s := -1
+			switch {
+			case rng.typ == typ && rng.subtype == subtype:
+				s = 2
+			case rng.typ == typ && rng.subtype == "*":
+				s = 1
+			case rng.typ == "*" && rng.subtype == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = rng.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// Negotiate returns the offer r's Accept header prefers, or "" if none is acceptable.
+func Negotiate(r *http.Request, offers ...string) string {
+	return negotiate(r.Header.Get("Accept"), offers)
+}
@@ -2,40 +2,111 @@ package middlewares
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/MarkMiraclee/gophermart/internal/auth"
+	"github.com/MarkMiraclee/gophermart/internal/logger"
+	"github.com/MarkMiraclee/gophermart/internal/models"
 )
 
 type contextKey string
 
 const UserIDKey contextKey = "userID"
 
-func Auth(jwtSecret string) func(http.Handler) http.Handler {
+// UserLookup resolves a user by ID; a nil user means it no longer exists.
+type UserLookup interface {
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+}
+
+// Auth authenticates requests by bearer token. When users is not nil, the token's
+// user is additionally checked to still exist, at the cost of a lookup per request.
+func Auth(keys *auth.Keyring, leeway time.Duration, users UserLookup, log logger.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
-				http.Error(w, "missing authorization header", http.StatusUnauthorized)
+				unauthorized(w, "", "missing authorization header")
 				return
 			}
 
 			headerParts := strings.Split(authHeader, " ")
 			if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-				http.Error(w, "invalid authorization header", http.StatusUnauthorized)
+				unauthorized(w, "invalid_request", "invalid authorization header")
 				return
 			}
 
 			tokenString := headerParts[1]
-			userID, err := auth.GetUserID(tokenString, jwtSecret)
+			userID, err := auth.GetUserID(tokenString, keys, leeway)
 			if err != nil {
-				http.Error(w, "invalid token", http.StatusUnauthorized)
+				switch {
+				case errors.Is(err, auth.ErrTokenExpired):
+					w.Header().Set("X-Token-Expired", "true")
+					unauthorized(w, "invalid_token", "token expired")
+				case errors.Is(err, auth.ErrTokenInvalidSignature):
+					// A well-formed token signed with another key: a rotated secret or a forgery attempt.
+					log.Warnf("rejected token with invalid signature from %s", r.RemoteAddr)
+					unauthorized(w, "invalid_token", "invalid token")
+				default:
+					unauthorized(w, "invalid_token", "invalid token")
+				}
 				return
 			}
 
+			if users != nil {
+				user, err := users.GetUserByID(r.Context(), userID)
+				if err != nil {
+					StorageError(w, err)
+					return
+				}
+				if user == nil {
+					unauthorized(w, "invalid_token", "user not found")
+					return
+				}
+			}
+
+			setRequestUserID(r, userID)
 			ctx := context.WithValue(r.Context(), UserIDKey, userID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// Admin allows only users flagged as administrators. It must run after Auth.
+func Admin(users UserLookup) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, _ := r.Context().Value(UserIDKey).(string)
+
+			user, err := users.GetUserByID(r.Context(), userID)
+			if err != nil {
+				StorageError(w, err)
+				return
+			}
+			if user == nil {
+				http.Error(w, "user not found", http.StatusUnauthorized)
+				return
+			}
+			if !user.IsAdmin {
+				http.Error(w, "admin access required", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// unauthorized replies 401 with a WWW-Authenticate challenge as described in RFC 6750;
+// errorCode is empty when the request carried no credentials at all.
+func unauthorized(w http.ResponseWriter, errorCode, description string) {
+	challenge := `Bearer realm="gophermart"`
+	if errorCode != "" {
+		challenge += fmt.Sprintf(`, error=%q, error_description=%q`, errorCode, description)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, description, http.StatusUnauthorized)
+}
@@ -0,0 +1,19 @@
+package middlewares
+
+import "net/http"
+
+// BodyLimit answers 413 to requests declaring a body larger than n bytes and cuts off longer
+// bodies of the others while the handler reads them. Placed after Gzip it limits the
+// decompressed size.
+func BodyLimit(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
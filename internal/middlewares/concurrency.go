@@ -0,0 +1,29 @@
+package middlewares
+
+import "net/http"
+
+// concurrencyRetryAfter is the Retry-After hint, in seconds, sent when all slots are busy.
+const concurrencyRetryAfter = "1"
+
+// ConcurrencyLimit serves at most n requests at a time and answers 503 with Retry-After right
+// away when all slots are taken, instead of queueing. The slots are shared by every handler the
+// middleware wraps, so it also limits a whole route group. A non-positive n disables the limit.
+func ConcurrencyLimit(n int) func(http.Handler) http.Handler {
+	slots := make(chan struct{}, max(n, 0))
+	return func(next http.Handler) http.Handler {
+		if n <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				w.Header().Set("Retry-After", concurrencyRetryAfter)
+				http.Error(w, "server is busy", http.StatusServiceUnavailable)
+				return
+			}
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MarkMiraclee/gophermart/internal/storage"
+)
+
+// poolRetryAfter is the Retry-After hint, in seconds, sent when the database pool is exhausted.
+const poolRetryAfter = "1"
+
+// StorageError replies 503 with Retry-After when no database connection was available in time
+// or the storage is closing for shutdown, so clients back off instead of treating it as a server
+// bug, and 500 otherwise. Handlers and middlewares share it so that both answer alike.
+func StorageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrPoolExhausted) || errors.Is(err, storage.ErrStorageClosed) {
+		w.Header().Set("Retry-After", poolRetryAfter)
+		http.Error(w, "service temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
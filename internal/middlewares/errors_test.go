@@ -0,0 +1,30 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MarkMiraclee/gophermart/internal/storage"
+)
+
+func TestStorageError(t *testing.T) {
+	tests := []struct {
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{fmt.Errorf("%w: timeout", storage.ErrPoolExhausted), http.StatusServiceUnavailable, poolRetryAfter},
+		{storage.ErrStorageClosed, http.StatusServiceUnavailable, poolRetryAfter},
+		{errors.New("syntax error"), http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		StorageError(rec, tt.err)
+		if rec.Code != tt.status || rec.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("%v: status %d, Retry-After %q; want %d, %q", tt.err, rec.Code, rec.Header().Get("Retry-After"), tt.status, tt.retryAfter)
+		}
+	}
+}
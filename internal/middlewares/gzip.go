@@ -1,44 +1,236 @@
 package middlewares
 
 import (
+	"bytes"
 	"compress/gzip"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 
-	"github.com/sirupsen/logrus"
+	"github.com/MarkMiraclee/gophermart/internal/logger"
 )
 
+// incompressibleTypes are media types that are compressed already; gzipping them again only costs CPU.
+var incompressibleTypes = map[string]struct{}{
+	"application/gzip":    {},
+	"application/x-gzip":  {},
+	"application/zip":     {},
+	"application/zstd":    {},
+	"application/x-bzip2": {},
+	"application/x-xz":    {},
+	"font/woff":           {},
+	"font/woff2":          {},
+}
+
+// gzipWriter holds back the response until minSize bytes have been written, then decides
+// whether to compress it. Shorter responses and already compressed content are sent as they are.
 type gzipWriter struct {
 	http.ResponseWriter
-	Writer io.Writer
+	level   int
+	minSize int
+
+	status  int
+	buf     bytes.Buffer
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		return w.write(b)
+	}
+	w.buf.Write(b)
+	if w.buf.Len() >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *gzipWriter) write(b []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide sends the header, compressing the body if it is large enough and compressible, and
+// then the buffered part of the body.
+func (w *gzipWriter) decide(largeEnough bool) error {
+	w.decided = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	header := w.Header()
+	if header.Get("Content-Type") == "" && w.buf.Len() > 0 {
+		// Sniff as net/http would, before the choice depends on the type.
+		header.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+	}
+	if largeEnough && bodyAllowed(w.status) && compressible(header) {
+		// The uncompressed length would make clients stop reading early or wait for bytes that
+		// never come; without it the body is sent chunked. A strong ETag no longer identifies
+		// these exact bytes either, so it is weakened.
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		gz, err := gzip.NewWriterLevel(w.ResponseWriter, w.level)
+		if err != nil {
+			return err
+		}
+		w.gz = gz
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// close sends what is still buffered and finishes the gzip stream.
+func (w *gzipWriter) close() error {
+	if !w.decided {
+		if w.status == 0 && w.buf.Len() == 0 {
+			// The handler wrote nothing at all; let net/http send its default response.
+			return nil
+		}
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// Flush sends the response so far; a streamed response is compressed whatever its size.
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func bodyAllowed(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+func compressible(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return true
+	}
+	if _, ok := incompressibleTypes[mediaType]; ok {
+		return false
+	}
+	typ, _, _ := strings.Cut(mediaType, "/")
+	return typ != "image" && typ != "video" && typ != "audio"
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip, honouring q=0 for
+// gzip itself or, without a gzip entry, for "*".
+func acceptsGzip(acceptEncoding string) bool {
+	gzipQ, wildcardQ := -1.0, -1.0
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(entry, ";")
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				continue
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			wildcardQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return wildcardQ > 0
+}
+
+type gzipReader struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+}
+
+func (r *gzipReader) Read(p []byte) (int, error) {
+	return r.zr.Read(p)
 }
 
-func (w gzipWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
+func (r *gzipReader) Close() error {
+	if err := r.zr.Close(); err != nil {
+		return err
+	}
+	return r.body.Close()
 }
 
-func Gzip(log *logrus.Logger) func(http.Handler) http.Handler {
+// Gzip decompresses gzip request bodies and compresses responses of at least minSize bytes at
+// the given level for clients that accept gzip.
+func Gzip(log logger.Logger, level, minSize int) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-				next.ServeHTTP(w, r)
-				return
+			if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
+				zr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					http.Error(w, "invalid gzip body", http.StatusBadRequest)
+					return
+				}
+				r.Body = &gzipReader{body: r.Body, zr: zr}
+				r.Header.Del("Content-Encoding")
+				r.Header.Del("Content-Length")
+				r.ContentLength = -1
 			}
 
-			gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				next.ServeHTTP(w, r)
 				return
 			}
+
+			gw := &gzipWriter{ResponseWriter: w, level: level, minSize: minSize}
 			defer func() {
-				if err := gz.Close(); err != nil {
-					log.Errorf("failed to close gzip writer: %v", err)
+				if err := gw.close(); err != nil {
+					log.Errorf("failed to finish gzip response: %v", err)
 				}
 			}()
-
-			w.Header().Set("Content-Encoding", "gzip")
-			next.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: gz}, r)
+			next.ServeHTTP(gw, r)
 		})
 	}
 }
@@ -0,0 +1,94 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MarkMiraclee/gophermart/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+func TestGzipOrdersDownload(t *testing.T) {
+	accrual := 500.0
+	uploaded := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	orders := make([]models.Order, 50)
+	for i := range orders {
+		orders[i] = models.Order{
+			Number:     strconv.Itoa(1000000 + i),
+			Status:     models.OrderStatusProcessed,
+			Accrual:    &accrual,
+			UploadedAt: uploaded,
+			UpdatedAt:  uploaded,
+		}
+	}
+	body, err := json.Marshal(orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	handler := Gzip(log, gzip.BestSpeed, 1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The length of the uncompressed body, which no longer matches once it is compressed.
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"orders-v1"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body)
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/user/orders", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	// Without transparent decompression the test sees the response as sent.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := resp.Header.Get("ETag"); got != `W/"orders-v1"` {
+		t.Errorf("ETag = %s, want it weakened", got)
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the response: %v", err)
+	}
+	// net/http may set the length again for a short response, but only the compressed one.
+	if got := resp.Header.Get("Content-Length"); got != "" && got != strconv.Itoa(len(raw)) {
+		t.Errorf("Content-Length = %s for %d bytes sent; the handler's stale value was %d", got, len(raw), len(body))
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading the compressed body: %v", err)
+	}
+	var got []models.Order
+	if err := json.Unmarshal(decompressed, &got); err != nil {
+		t.Fatalf("decompressed body is not the JSON list: %v", err)
+	}
+	if !reflect.DeepEqual(got, orders) {
+		t.Error("decompressed orders differ from the ones sent")
+	}
+}
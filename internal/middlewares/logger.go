@@ -1,15 +1,39 @@
 package middlewares
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"net/http"
 	"time"
 
+	"github.com/MarkMiraclee/gophermart/internal/logger"
 	"github.com/sirupsen/logrus"
 )
 
+// requestInfo is shared through the request context so that middlewares running
+// after Logger (such as Auth) can report details back to it.
+type requestInfo struct {
+	userID string
+}
+
+const requestInfoKey contextKey = "requestInfo"
+
+func setRequestUserID(r *http.Request, userID string) {
+	if info, ok := r.Context().Value(requestInfoKey).(*requestInfo); ok {
+		info.userID = userID
+	}
+}
+
+// maxLoggedBody caps how much of each request and response body is logged at debug level.
+const maxLoggedBody = 4 << 10
+
 type responseData struct {
 	status int
 	size   int
+	// body holds the first maxLoggedBody bytes of the response; nil unless bodies are logged.
+	body *bytes.Buffer
 }
 
 type loggingResponseWriter struct {
@@ -20,6 +44,9 @@ type loggingResponseWriter struct {
 func (r *loggingResponseWriter) Write(b []byte) (int, error) {
 	size, err := r.ResponseWriter.Write(b)
 	r.responseData.size += size
+	if body := r.responseData.body; body != nil && body.Len() < maxLoggedBody {
+		body.Write(b[:min(size, maxLoggedBody-body.Len())])
+	}
 	return size, err
 }
 
@@ -28,7 +55,17 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
-func Logger(log *logrus.Logger) func(http.Handler) http.Handler {
+func (r *loggingResponseWriter) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func Logger(log logger.Logger) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
@@ -42,17 +79,72 @@ func Logger(log *logrus.Logger) func(http.Handler) http.Handler {
 				responseData:   responseData,
 			}
 
-			h.ServeHTTP(&lw, r)
+			logBodies := log.IsLevelEnabled(logrus.DebugLevel)
+			var (
+				requestBody      []byte
+				requestTruncated bool
+			)
+			if logBodies {
+				requestBody, requestTruncated = peekBody(r)
+				responseData.body = &bytes.Buffer{}
+			}
+
+			info := &requestInfo{}
+			h.ServeHTTP(&lw, r.WithContext(context.WithValue(r.Context(), requestInfoKey, info)))
 
 			duration := time.Since(start)
 
-			log.WithFields(logrus.Fields{
+			fields := logrus.Fields{
 				"uri":      r.RequestURI,
 				"method":   r.Method,
 				"status":   responseData.status,
 				"duration": duration,
 				"size":     responseData.size,
-			}).Info("request completed")
+			}
+			if info.userID != "" {
+				fields["user_id"] = info.userID
+			}
+			log.WithFields(fields).Info("request completed")
+
+			if logBodies {
+				log.WithFields(logrus.Fields{
+					"uri":           r.RequestURI,
+					"method":        r.Method,
+					"headers":       logger.SanitizeHeaders(r.Header),
+					"request_body":  loggableBody(requestBody, requestTruncated, r.Header.Get("Content-Encoding")),
+					"response_body": loggableBody(responseData.body.Bytes(), responseData.size > responseData.body.Len(), lw.Header().Get("Content-Encoding")),
+				}).Debug("request bodies")
+			}
 		})
 	}
 }
+
+// peekBody reads up to maxLoggedBody bytes of the request body and puts them back in front
+// of the unread rest, so the handler still sees the complete body.
+func peekBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, false
+	}
+	head, _ := io.ReadAll(io.LimitReader(r.Body, maxLoggedBody+1))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(head), r.Body))
+	if len(head) > maxLoggedBody {
+		return head[:maxLoggedBody], true
+	}
+	return head, false
+}
+
+// loggableBody renders a captured body prefix. Compressed bodies are only described, since
+// Logger runs outside Gzip and sees the encoded bytes.
+func loggableBody(body []byte, truncated bool, contentEncoding string) string {
+	if contentEncoding != "" {
+		return fmt.Sprintf("[%s-encoded body omitted]", contentEncoding)
+	}
+	if len(body) == 0 {
+		return ""
+	}
+	text := redactBody(body)
+	if truncated {
+		text += " [truncated]"
+	}
+	return text
+}
@@ -0,0 +1,87 @@
+package middlewares
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// Limiters of users without a request for limiterIdleTTL are full again and can be dropped;
+	// the map is swept for them at most once per limiterSweepInterval.
+	limiterIdleTTL       = 10 * time.Minute
+	limiterSweepInterval = 1 * time.Minute
+)
+
+type userEntry struct {
+	limiter *rate.Limiter
+	last    time.Time
+}
+
+type userLimiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	users     map[string]*userEntry
+	lastSweep time.Time
+}
+
+// allow takes a token from the user's limiter, or reports how long until the next one is available.
+func (l *userLimiter) allow(userID string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= limiterSweepInterval {
+		for id, entry := range l.users {
+			if now.Sub(entry.last) >= limiterIdleTTL {
+				delete(l.users, id)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	entry, ok := l.users[userID]
+	if !ok {
+		entry = &userEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.users[userID] = entry
+	}
+	entry.last = now
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		// A rejected request must not use up the token it would have waited for.
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// RateLimit limits each authenticated user to perSecond requests per second with bursts of up
+// to burst requests, answering 429 with Retry-After beyond that. It must run after Auth.
+// The limit is shared by every handler the middleware wraps, so a route group counts as a whole
+// rather than per route. A non-positive rate disables the limit.
+func RateLimit(perSecond float64, burst int) func(http.Handler) http.Handler {
+	limiter := &userLimiter{
+		limit: rate.Limit(perSecond),
+		burst: max(burst, 1),
+		users: make(map[string]*userEntry),
+	}
+	return func(next http.Handler) http.Handler {
+		if perSecond <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, _ := r.Context().Value(UserIDKey).(string)
+			if ok, wait := limiter.allow(userID, time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestUserLimiter(t *testing.T) {
+	l := &userLimiter{limit: rate.Limit(2), burst: 2, users: make(map[string]*userEntry)}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d within the burst was rejected", i+1)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	if wait <= 0 || wait > 500*time.Millisecond {
+		t.Errorf("wait = %v, want (0, 500ms]", wait)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Error("another user's request was rejected")
+	}
+	// The rejected request gave its token back, so it is available after exactly one interval.
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Error("request after the refill interval was rejected")
+	}
+
+	l.allow("b", now.Add(time.Second+limiterIdleTTL))
+	if _, ok := l.users["a"]; ok {
+		t.Error("idle user was not swept")
+	}
+}
+
+func TestRateLimitIsSharedAcrossRoutes(t *testing.T) {
+	limit := RateLimit(1, 1)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	first, second := limit(ok), limit(ok)
+
+	request := func(h http.Handler) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), UserIDKey, "user"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := request(first); code != http.StatusOK {
+		t.Fatalf("first request: status = %d, want %d", code, http.StatusOK)
+	}
+	if code := request(second); code != http.StatusTooManyRequests {
+		t.Errorf("request to another route: status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,64 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveFields are JSON keys whose values never reach the logs: passwords in register/login
+// requests and tokens in their responses.
+var sensitiveFields = []string{"password", "token"}
+
+// redactBody prepares a possibly truncated body for logging. JSON is re-encoded with sensitive
+// values replaced; anything that cannot be parsed is dropped if it mentions a sensitive key,
+// since a cut-off JSON document may still contain a credential.
+func redactBody(body []byte) string {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		lower := bytes.ToLower(body)
+		for _, field := range sensitiveFields {
+			if bytes.Contains(lower, []byte(field)) {
+				return "[omitted: may contain credentials]"
+			}
+		}
+		return string(body)
+	}
+
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return "[omitted: may contain credentials]"
+	}
+	return string(out)
+}
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if isSensitiveField(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+	}
+	return v
+}
+
+func isSensitiveField(key string) bool {
+	for _, field := range sensitiveFields {
+		if strings.EqualFold(key, field) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Timeout cancels the request context after d and answers 503 if the handler has not finished by then.
+// A non-positive d disables the limit.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.TimeoutHandler(next, d, "request timed out")
+	}
+}
+
+// Deadline cancels the request context after d but, unlike Timeout, neither buffers the response
+// nor answers on the handler's behalf, so that streaming handlers can flush. A non-positive d
+// disables the limit.
+func Deadline(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
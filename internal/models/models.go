@@ -1,22 +1,70 @@
 package models
 
 import (
+	"strings"
 	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// OrderStatus is the accrual processing state of an uploaded order.
+type OrderStatus string
+
+const (
+	OrderStatusNew        OrderStatus = "NEW"
+	OrderStatusProcessing OrderStatus = "PROCESSING"
+	OrderStatusInvalid    OrderStatus = "INVALID"
+	OrderStatusProcessed  OrderStatus = "PROCESSED"
 )
 
+// PendingOrderStatuses are the statuses the accrual poller keeps checking.
+var PendingOrderStatuses = []OrderStatus{OrderStatusNew, OrderStatusProcessing}
+
+// IsValid reports whether s is one of the known order statuses.
+func (s OrderStatus) IsValid() bool {
+	switch s {
+	case OrderStatusNew, OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed:
+		return true
+	}
+	return false
+}
+
+// IsTerminal reports whether s is a final status that is never polled or changed again.
+func (s OrderStatus) IsTerminal() bool {
+	return s == OrderStatusInvalid || s == OrderStatusProcessed
+}
+
 type User struct {
-	ID           string `json:"id"`
-	Login        string `json:"login"`
-	PasswordHash string `json:"-"`
+	ID           string    `json:"id"`
+	Login        string    `json:"login"`
+	PasswordHash string    `json:"-"`
+	IsAdmin      bool      `json:"is_admin"`
+	CreatedAt    time.Time `json:"created_at"`
 }
 
 type Order struct {
-	ID         string    `json:"-"`
-	UserID     string    `json:"-"`
-	Number     string    `json:"number"`
-	Status     string    `json:"status"`
-	Accrual    *float64  `json:"accrual,omitempty"`
-	UploadedAt time.Time `json:"uploaded_at"`
+	ID         string      `json:"-"`
+	UserID     string      `json:"-"`
+	Number     string      `json:"number"`
+	Status     OrderStatus `json:"status"`
+	Accrual    *float64    `json:"accrual,omitempty"`
+	UploadedAt time.Time   `json:"uploaded_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	Attempts   int         `json:"-"`
+}
+
+type OrderUpdate struct {
+	Number  string
+	Status  OrderStatus
+	Accrual *float64
+}
+
+// OrderEvent is the payload sent to the outbound webhook.
+type OrderEvent struct {
+	Order   string      `json:"order"`
+	Status  OrderStatus `json:"status"`
+	Accrual *float64    `json:"accrual,omitempty"`
+	UserID  string      `json:"user_id"`
 }
 
 type Withdrawal struct {
@@ -27,11 +75,85 @@ type Withdrawal struct {
 	ProcessedAt time.Time `json:"processed_at"`
 }
 
+// OrderSortField is a column the orders list can be sorted by.
+type OrderSortField string
+
+const (
+	OrderSortUploadedAt OrderSortField = "uploaded_at"
+	OrderSortStatus     OrderSortField = "status"
+	OrderSortAccrual    OrderSortField = "accrual"
+)
+
+// OrderFilter controls an orders listing. The zero value sorts by upload time, newest first;
+// zero Limit means no limit. After is only valid with the default sort.
+type OrderFilter struct {
+	SortBy    OrderSortField
+	Ascending bool
+	Limit     int
+	Offset    int
+	After     *OrderCursor
+}
+
+// OrderCursor is the keyset position of the last order on a page sorted by upload time, newest first.
+type OrderCursor struct {
+	UploadedAt time.Time
+	Number     string
+}
+
+// WithdrawalFilter narrows a withdrawals listing. From is inclusive and To is exclusive;
+// zero Limit means no limit.
+type WithdrawalFilter struct {
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+// ListVersion summarizes a listing independent of paging: it changes whenever an item is
+// added, removed or modified.
+type ListVersion struct {
+	Count        int
+	LastModified time.Time
+}
+
 type Balance struct {
 	Current   float64 `json:"current"`
 	Withdrawn float64 `json:"withdrawn"`
 }
 
+// LedgerEntryType tells accruals and withdrawals apart in the ledger.
+type LedgerEntryType string
+
+const (
+	LedgerEntryAccrual    LedgerEntryType = "accrual"
+	LedgerEntryWithdrawal LedgerEntryType = "withdrawal"
+)
+
+// LedgerEntry is one balance change: Amount is positive for accruals and negative for withdrawals.
+type LedgerEntry struct {
+	Type   LedgerEntryType `json:"type"`
+	Amount float64         `json:"amount"`
+	Order  string          `json:"order"`
+	At     time.Time       `json:"at"`
+}
+
+type BalanceSummary struct {
+	Current         float64 `json:"current"`
+	Withdrawn       float64 `json:"withdrawn"`
+	TotalAccrued    float64 `json:"total_accrued"`
+	OrderCount      int     `json:"order_count"`
+	WithdrawalCount int     `json:"withdrawal_count"`
+}
+
+// OrderStats counts a user's orders per status.
+type OrderStats struct {
+	New          int     `json:"NEW"`
+	Processing   int     `json:"PROCESSING"`
+	Processed    int     `json:"PROCESSED"`
+	Invalid      int     `json:"INVALID"`
+	TotalAccrued float64 `json:"total_accrued"`
+}
+
 type RegisterRequest struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
@@ -42,6 +164,40 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// NormalizeLogin trims surrounding white space and converts the login to Unicode NFC, so that
+// visually identical logins are stored and looked up alike. Case is kept for display; logins
+// are compared case-insensitively by the storage.
+func NormalizeLogin(login string) string {
+	return norm.NFC.String(strings.TrimSpace(login))
+}
+
+type AuthResponse struct {
+	Token string `json:"token"`
+}
+
+type OrderRequest struct {
+	Order string `json:"order"`
+}
+
+// BatchOrderOutcome is the per-item result of a bulk order upload.
+type BatchOrderOutcome string
+
+const (
+	BatchOrderAccepted     BatchOrderOutcome = "accepted"
+	BatchOrderDuplicate    BatchOrderOutcome = "duplicate"
+	BatchOrderInvalid      BatchOrderOutcome = "invalid"
+	BatchOrderOwnedByOther BatchOrderOutcome = "owned_by_other"
+	// BatchOrderQuotaExceeded marks new numbers that would take the user past MAX_ORDERS_PER_USER.
+	BatchOrderQuotaExceeded BatchOrderOutcome = "quota_exceeded"
+)
+
+type BatchOrderResult struct {
+	Order  string            `json:"order"`
+	Result BatchOrderOutcome `json:"result"`
+	// OwnerID is the uploader of an owned_by_other order, for logging; it is never sent to clients.
+	OwnerID string `json:"-"`
+}
+
 type WithdrawRequest struct {
 	Order string  `json:"order"`
 	Sum   float64 `json:"sum"`
@@ -0,0 +1,18 @@
+package models
+
+import "testing"
+
+func TestPendingOrderStatusesExcludeTerminal(t *testing.T) {
+	pending := make(map[OrderStatus]bool)
+	for _, status := range PendingOrderStatuses {
+		if status.IsTerminal() {
+			t.Errorf("terminal status %s is polled", status)
+		}
+		pending[status] = true
+	}
+	for _, status := range []OrderStatus{OrderStatusNew, OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed} {
+		if !status.IsTerminal() && !pending[status] {
+			t.Errorf("non-terminal status %s is never polled", status)
+		}
+	}
+}
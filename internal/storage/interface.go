@@ -2,22 +2,47 @@ package storage
 
 import (
 	"context"
+	"time"
+
 	"github.com/MarkMiraclee/gophermart/internal/models"
 )
 
 type Storage interface {
 	CreateUser(ctx context.Context, login, passwordHash string) (*models.User, error)
 	GetUserByLogin(ctx context.Context, login string) (*models.User, error)
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	UpdatePasswordHash(ctx context.Context, userID, passwordHash string) error
+	ListUsers(ctx context.Context, limit, offset int) ([]models.User, error)
+	DeleteUser(ctx context.Context, userID string) error
 
-	CreateOrder(ctx context.Context, userID, orderNumber string) error
+	CreateOrder(ctx context.Context, userID, orderNumber string, maxOrders int) error
+	CreateOrdersBatch(ctx context.Context, userID string, orderNumbers []string, maxOrders int) ([]models.BatchOrderResult, error)
 	GetOrderByNumber(ctx context.Context, orderNumber string) (*models.Order, error)
-	GetOrdersByUser(ctx context.Context, userID string) ([]models.Order, error)
-	GetOrdersByStatus(ctx context.Context, statuses []string) ([]models.Order, error)
-	UpdateOrder(ctx context.Context, orderNumber, status string, accrual *float64) error
+	GetUserOrder(ctx context.Context, userID, orderNumber string) (*models.Order, error)
+	DeleteOrder(ctx context.Context, userID, orderNumber string) error
+	RefreshOrder(ctx context.Context, userID, orderNumber string) error
+	GetOrdersByUser(ctx context.Context, userID string, filter models.OrderFilter) ([]models.Order, error)
+	GetOrdersUpdatedSince(ctx context.Context, userID string, since time.Time) ([]models.Order, error)
+	GetOrdersVersion(ctx context.Context, userID string) (*models.ListVersion, error)
+	CountOrdersByUser(ctx context.Context, userID string) (int, error)
+	GetOrderStats(ctx context.Context, userID string) (*models.OrderStats, error)
+	GetOrdersByStatus(ctx context.Context, statuses []models.OrderStatus, checkedBefore time.Time) ([]models.Order, error)
+	UpdateOrder(ctx context.Context, orderNumber string, status models.OrderStatus, accrual *float64) error
+	MarkOrdersPolled(ctx context.Context, orderNumbers []string) error
+	InvalidateStaleOrders(ctx context.Context, statuses []models.OrderStatus, uploadedBefore time.Time) (int64, error)
+	GetOldestUploadedAt(ctx context.Context, statuses []models.OrderStatus) (*time.Time, error)
+	UpdateOrdersBatch(ctx context.Context, updates []models.OrderUpdate) ([]models.Order, error)
 
 	GetBalance(ctx context.Context, userID string) (*models.Balance, error)
-	CreateWithdrawal(ctx context.Context, userID, orderNumber string, sum float64) error
-	GetWithdrawalsByUser(ctx context.Context, userID string) ([]models.Withdrawal, error)
+	GetBalanceSummary(ctx context.Context, userID string) (*models.BalanceSummary, error)
+	CheckWithdrawal(ctx context.Context, userID string, sum float64) (*models.Balance, error)
+	CreateWithdrawal(ctx context.Context, userID, orderNumber string, sum float64) (*models.Balance, error)
+	GetWithdrawalsByUser(ctx context.Context, userID string, filter models.WithdrawalFilter) ([]models.Withdrawal, error)
+	GetWithdrawalsVersion(ctx context.Context, userID string, filter models.WithdrawalFilter) (*models.ListVersion, error)
+	CountWithdrawalsByUser(ctx context.Context, userID string, filter models.WithdrawalFilter) (int, error)
+	GetLedgerByUser(ctx context.Context, userID string, limit, offset int) ([]models.LedgerEntry, error)
+	CountLedgerByUser(ctx context.Context, userID string) (int, error)
 
+	Ready(ctx context.Context) error
 	Close()
 }
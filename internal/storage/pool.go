@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// acquireTimeout bounds the wait for a free connection. It is well below the handler timeout so
+// that an exhausted pool is reported to the client instead of running into the request deadline.
+const acquireTimeout = 2 * time.Second
+
+// pool acquires connections with acquireTimeout and reports an acquire timeout as
+// ErrPoolExhausted, so that callers can tell "no free connection" apart from a failing query.
+// Once closed is set, every call fails with ErrStorageClosed instead of pgx's closed-pool error.
+type pool struct {
+	*pgxpool.Pool
+	closed *atomic.Bool
+}
+
+func (p pool) acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	if p.closed.Load() {
+		return nil, ErrStorageClosed
+	}
+	acquireCtx, cancel := context.WithTimeout(ctx, acquireTimeout)
+	defer cancel()
+
+	conn, err := p.Pool.Acquire(acquireCtx)
+	if err != nil {
+		switch {
+		case p.closed.Load():
+			// Closed while waiting for a connection.
+			return nil, ErrStorageClosed
+		case errors.Is(err, context.DeadlineExceeded):
+			return nil, fmt.Errorf("%w: %w", ErrPoolExhausted, err)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (p pool) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	if p.closed.Load() {
+		return errBatchResults{err: ErrStorageClosed}
+	}
+	return p.Pool.SendBatch(ctx, b)
+}
+
+func (p pool) Ping(ctx context.Context) error {
+	if p.closed.Load() {
+		return ErrStorageClosed
+	}
+	return p.Pool.Ping(ctx)
+}
+
+func (p pool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	defer conn.Release()
+	return conn.Exec(ctx, sql, args...)
+}
+
+func (p pool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		conn.Release()
+		return nil, err
+	}
+	return &connRows{Rows: rows, conn: conn}, nil
+}
+
+func (p pool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return errRow{err: err}
+	}
+	return &connRow{row: conn.QueryRow(ctx, sql, args...), conn: conn}
+}
+
+func (p pool) Begin(ctx context.Context) (pgx.Tx, error) {
+	conn, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		conn.Release()
+		return nil, err
+	}
+	return &connTx{Tx: tx, conn: conn}, nil
+}
+
+// The wrappers below return the connection to the pool once the result is consumed.
+// pgxpool.Conn.Release is idempotent, so repeated Close/Rollback calls are harmless.
+
+type connRows struct {
+	pgx.Rows
+	conn *pgxpool.Conn
+}
+
+func (r *connRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.Close()
+	return false
+}
+
+func (r *connRows) Close() {
+	r.Rows.Close()
+	r.conn.Release()
+}
+
+type connRow struct {
+	row  pgx.Row
+	conn *pgxpool.Conn
+}
+
+func (r *connRow) Scan(dest ...any) error {
+	defer r.conn.Release()
+	return r.row.Scan(dest...)
+}
+
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...any) error {
+	return r.err
+}
+
+type errBatchResults struct {
+	err error
+}
+
+func (r errBatchResults) Exec() (pgconn.CommandTag, error) { return pgconn.CommandTag{}, r.err }
+func (r errBatchResults) Query() (pgx.Rows, error)         { return nil, r.err }
+func (r errBatchResults) QueryRow() pgx.Row                { return errRow{err: r.err} }
+func (r errBatchResults) Close() error                     { return r.err }
+
+type connTx struct {
+	pgx.Tx
+	conn *pgxpool.Conn
+}
+
+func (t *connTx) Commit(ctx context.Context) error {
+	defer t.conn.Release()
+	return t.Tx.Commit(ctx)
+}
+
+func (t *connTx) Rollback(ctx context.Context) error {
+	defer t.conn.Release()
+	return t.Tx.Rollback(ctx)
+}
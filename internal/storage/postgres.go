@@ -3,48 +3,168 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
 	"time"
 
+	"github.com/MarkMiraclee/gophermart/internal/logger"
 	"github.com/MarkMiraclee/gophermart/internal/models"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/sirupsen/logrus"
 )
 
 var (
-	ErrLoginExists       = errors.New("login already exists")
-	ErrOrderExists       = errors.New("order already exists for this user")
-	ErrOrderExistsOther  = errors.New("order already exists for another user")
-	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrLoginExists        = errors.New("login already exists")
+	ErrOrderExists        = errors.New("order already exists for this user")
+	ErrOrderExistsOther   = errors.New("order already exists for another user")
+	ErrInsufficientFunds  = errors.New("insufficient funds")
+	ErrInvalidSum         = errors.New("sum must be a positive finite number")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrOrderNotFound      = errors.New("order not found")
+	ErrOrderNotPending    = errors.New("order has already been processed")
+	ErrNotMigrated        = errors.New("database schema is missing")
+	ErrPoolExhausted      = errors.New("no database connection available")
+	ErrOrderQuotaExceeded = errors.New("order quota exceeded")
+	ErrStorageClosed      = errors.New("storage is closed")
 )
 
+// Unique constraints whose violations are translated into domain errors: the names PostgreSQL
+// generates for the UNIQUE columns in migrate, and the case-insensitive login index.
+const (
+	constraintUserLogin      = "users_login_key"
+	constraintUserLoginLower = "users_login_lower_key"
+	constraintOrderNumber    = "orders_number_key"
+)
+
+// isUniqueViolation reports whether err is a unique_violation of the named constraint, so that
+// a violation of any other constraint is not mistaken for it.
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
+// OrderOwnerError matches ErrOrderExistsOther and tells who uploaded the order number.
+type OrderOwnerError struct {
+	OwnerID string
+}
+
+func (e *OrderOwnerError) Error() string { return ErrOrderExistsOther.Error() }
+func (e *OrderOwnerError) Unwrap() error { return ErrOrderExistsOther }
+
+// updateOrderQuery never touches orders that already reached a terminal status, and leaves
+// updated_at alone when the accrual system reports what is already stored. An order turning
+// PROCESSED credits its accrual to the user's balance in the same statement.
+const updateOrderQuery = `WITH updated AS (
+		UPDATE orders SET status = $1, accrual = $2, updated_at = $4
+		WHERE number = $3 AND status NOT IN ('PROCESSED', 'INVALID')
+		AND (status IS DISTINCT FROM $1 OR accrual IS DISTINCT FROM $2)
+		RETURNING user_id, number, status, accrual, uploaded_at, updated_at
+	), credited AS (
+		INSERT INTO balances (user_id, current)
+		SELECT user_id, accrual FROM updated WHERE status = 'PROCESSED' AND accrual IS NOT NULL
+		ON CONFLICT (user_id) DO UPDATE SET current = balances.current + EXCLUDED.current
+	)
+	SELECT user_id, number, status, accrual, uploaded_at, updated_at FROM updated`
+
+var orderSortColumns = map[models.OrderSortField]string{
+	models.OrderSortUploadedAt: "uploaded_at",
+	models.OrderSortStatus:     "status",
+	models.OrderSortAccrual:    "accrual",
+}
+
 type PostgresStorage struct {
-	pool *pgxpool.Pool
-	log  *logrus.Logger
+	pool pool
+	// replica serves read-only list and balance queries; it is the primary pool when no replica is configured.
+	replica pool
+	log     logger.Logger
 }
 
-func NewPostgresStorage(ctx context.Context, dsn string, log *logrus.Logger) (*PostgresStorage, error) {
-	pool, err := pgxpool.New(ctx, dsn)
+// PoolOptions tune how the connection pools recycle idle connections.
+type PoolOptions struct {
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+}
+
+func NewPostgresStorage(ctx context.Context, dsn, replicaDSN string, opts PoolOptions, log logger.Logger) (*PostgresStorage, error) {
+	primary, err := newPool(ctx, dsn, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := pool.Ping(ctx); err != nil {
-		return nil, err
+	replica := primary
+	if replicaDSN != "" {
+		replica, err = newPool(ctx, replicaDSN, opts)
+		if err != nil {
+			primary.Close()
+			return nil, fmt.Errorf("failed to connect to replica: %w", err)
+		}
 	}
 
-	storage := &PostgresStorage{pool: pool, log: log}
+	// Both pools share the flag, so closing the storage stops reads and writes alike.
+	closed := &atomic.Bool{}
+	storage := &PostgresStorage{pool: pool{primary, closed}, replica: pool{replica, closed}, log: log}
 	if err := storage.runMigrations(ctx); err != nil {
+		storage.Close()
 		return nil, err
 	}
 
 	return storage, nil
 }
 
+func newPool(ctx context.Context, dsn string, opts PoolOptions) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	// Some managed Postgres services silently drop idle connections; closing them first
+	// keeps the next query after a quiet period from failing on a dead connection.
+	if opts.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = opts.MaxConnIdleTime
+	}
+	if opts.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = opts.HealthCheckPeriod
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+// migrationLockID keys the advisory lock that keeps instances starting together from migrating
+// at the same time.
+const migrationLockID = 0x676f7068 // "goph"
+
+// runMigrations applies the schema in one transaction, so that a failure anywhere, such as
+// duplicate logins blocking the case-insensitive index, leaves the database as it was and the
+// whole migration, balance seeding included, is retried on the next start.
 func (s *PostgresStorage) runMigrations(ctx context.Context) error {
-	_, err := s.pool.Exec(ctx, `
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		return migrate(ctx, tx)
+	})
+}
+
+func migrate(ctx context.Context, tx pgx.Tx) error {
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+		return err
+	}
+
+	var backfillBalances bool
+	if err := tx.QueryRow(ctx, "SELECT to_regclass('balances') IS NULL").Scan(&backfillBalances); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(ctx, `
 		CREATE TABLE IF NOT EXISTS users (
 			id UUID PRIMARY KEY,
 			login VARCHAR(255) UNIQUE NOT NULL,
@@ -53,7 +173,7 @@ func (s *PostgresStorage) runMigrations(ctx context.Context) error {
 
 		CREATE TABLE IF NOT EXISTS orders (
 			id UUID PRIMARY KEY,
-			user_id UUID REFERENCES users(id),
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
 			number VARCHAR(255) UNIQUE NOT NULL,
 			status VARCHAR(50) NOT NULL,
 			accrual NUMERIC,
@@ -62,29 +182,125 @@ func (s *PostgresStorage) runMigrations(ctx context.Context) error {
 
 		CREATE TABLE IF NOT EXISTS withdrawals (
 			id UUID PRIMARY KEY,
-			user_id UUID REFERENCES users(id),
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
 			order_number VARCHAR(255) NOT NULL,
 			sum NUMERIC NOT NULL,
 			processed_at TIMESTAMPTZ NOT NULL
 		);
+
+		-- Tables created before the foreign keys cascaded keep the old ones, which make deleting
+		-- a user fail; they are replaced unless they cascade already.
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'orders_user_id_fkey' AND conrelid = 'orders'::regclass AND confdeltype = 'c') THEN
+				ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_user_id_fkey,
+					ADD CONSTRAINT orders_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+			END IF;
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'withdrawals_user_id_fkey' AND conrelid = 'withdrawals'::regclass AND confdeltype = 'c') THEN
+				ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_user_id_fkey,
+					ADD CONSTRAINT withdrawals_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+			END IF;
+		END $$;
+
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+		ALTER TABLE orders ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE orders ADD COLUMN IF NOT EXISTS last_checked_at TIMESTAMPTZ;
+		-- Only backfilled when the column is added, instead of scanning orders on every start.
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'orders' AND column_name = 'updated_at') THEN
+				ALTER TABLE orders ADD COLUMN updated_at TIMESTAMPTZ;
+				UPDATE orders SET updated_at = uploaded_at;
+				ALTER TABLE orders ALTER COLUMN updated_at SET NOT NULL;
+			END IF;
+		END $$;
+		CREATE INDEX IF NOT EXISTS orders_user_id_updated_at_idx ON orders (user_id, updated_at);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Fails on existing logins that differ only in case; those accounts must be renamed or
+	// merged by hand before upgrading. It comes before the balances table, so nothing that
+	// assumes a finished migration exists yet if it fails.
+	_, err = tx.Exec(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS "+constraintUserLoginLower+" ON users (lower(login))")
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("logins differing only in case exist, rename them before upgrading: %w", err)
+		}
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS balances (
+			user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			current NUMERIC NOT NULL DEFAULT 0,
+			withdrawn NUMERIC NOT NULL DEFAULT 0
+		)
+	`)
+	if err != nil || !backfillBalances {
+		return err
+	}
+
+	// The balances table is new: seed it once from the order and withdrawal history it replaces.
+	_, err = tx.Exec(ctx, `
+		INSERT INTO balances (user_id, current, withdrawn)
+		SELECT u.id, COALESCE(o.accrued, 0) - COALESCE(w.withdrawn, 0), COALESCE(w.withdrawn, 0)
+		FROM users u
+		LEFT JOIN (SELECT user_id, SUM(accrual) AS accrued FROM orders WHERE status = 'PROCESSED' GROUP BY user_id) o ON o.user_id = u.id
+		LEFT JOIN (SELECT user_id, SUM(sum) AS withdrawn FROM withdrawals GROUP BY user_id) w ON w.user_id = u.id
+		WHERE o.accrued IS NOT NULL OR w.withdrawn IS NOT NULL
+		ON CONFLICT (user_id) DO NOTHING
 	`)
 	return err
 }
 
+// Ready checks that the primary and the replica answer and that the schema is in place.
+func (s *PostgresStorage) Ready(ctx context.Context) error {
+	const query = "SELECT to_regclass('users') IS NOT NULL AND to_regclass('orders') IS NOT NULL AND to_regclass('withdrawals') IS NOT NULL AND to_regclass('balances') IS NOT NULL"
+
+	var migrated bool
+	if err := s.pool.QueryRow(ctx, query).Scan(&migrated); err != nil {
+		return err
+	}
+	if !migrated {
+		return ErrNotMigrated
+	}
+	if s.replica != s.pool {
+		if err := s.replica.Ping(ctx); err != nil {
+			return fmt.Errorf("replica: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the pools; calls made afterwards fail with ErrStorageClosed. Calls still in
+// flight finish first, since closing a pgxpool waits for acquired connections to be released.
 func (s *PostgresStorage) Close() {
+	if s.pool.closed.Swap(true) {
+		return
+	}
+	if s.replica != s.pool {
+		s.replica.Close()
+	}
 	s.pool.Close()
 }
 
+// CreateUser stores the login normalized but in its original case; ErrLoginExists means a login
+// differing at most in case is taken.
 func (s *PostgresStorage) CreateUser(ctx context.Context, login, passwordHash string) (*models.User, error) {
 	user := &models.User{
 		ID:           uuid.NewString(),
-		Login:        login,
+		Login:        models.NormalizeLogin(login),
 		PasswordHash: passwordHash,
+		CreatedAt:    time.Now(),
 	}
-	_, err := s.pool.Exec(ctx, "INSERT INTO users (id, login, password_hash) VALUES ($1, $2, $3)", user.ID, user.Login, user.PasswordHash)
+	_, err := s.pool.Exec(ctx, "INSERT INTO users (id, login, password_hash, created_at) VALUES ($1, $2, $3, $4)",
+		user.ID, user.Login, user.PasswordHash, user.CreatedAt)
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+		if isUniqueViolation(err, constraintUserLogin) || isUniqueViolation(err, constraintUserLoginLower) {
 			return nil, ErrLoginExists
 		}
 		return nil, err
@@ -92,9 +308,11 @@ func (s *PostgresStorage) CreateUser(ctx context.Context, login, passwordHash st
 	return user, nil
 }
 
+// GetUserByLogin finds the user whose login matches case-insensitively after normalization.
 func (s *PostgresStorage) GetUserByLogin(ctx context.Context, login string) (*models.User, error) {
 	user := &models.User{}
-	err := s.pool.QueryRow(ctx, "SELECT id, login, password_hash FROM users WHERE login = $1", login).Scan(&user.ID, &user.Login, &user.PasswordHash)
+	err := s.pool.QueryRow(ctx, "SELECT id, login, password_hash, is_admin, created_at FROM users WHERE lower(login) = lower($1)", models.NormalizeLogin(login)).
+		Scan(&user.ID, &user.Login, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil // User not found
@@ -104,12 +322,107 @@ func (s *PostgresStorage) GetUserByLogin(ctx context.Context, login string) (*mo
 	return user, nil
 }
 
-func (s *PostgresStorage) CreateOrder(ctx context.Context, userID, orderNumber string) error {
-	_, err := s.pool.Exec(ctx, "INSERT INTO orders (id, user_id, number, status, uploaded_at) VALUES ($1, $2, $3, $4, $5)",
-		uuid.NewString(), userID, orderNumber, "NEW", time.Now())
+func (s *PostgresStorage) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	user := &models.User{}
+	err := s.pool.QueryRow(ctx, "SELECT id, login, password_hash, is_admin, created_at FROM users WHERE id = $1", id).
+		Scan(&user.ID, &user.Login, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt)
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // User not found
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *PostgresStorage) UpdatePasswordHash(ctx context.Context, userID, passwordHash string) error {
+	_, err := s.pool.Exec(ctx, "UPDATE users SET password_hash = $1 WHERE id = $2", passwordHash, userID)
+	return err
+}
+
+func (s *PostgresStorage) ListUsers(ctx context.Context, limit, offset int) ([]models.User, error) {
+	rows, err := s.replica.Query(ctx, "SELECT id, login, is_admin, created_at FROM users ORDER BY created_at, login LIMIT $1 OFFSET $2", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Login, &user.IsAdmin, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// DeleteUser removes the user together with their withdrawals and orders in one transaction.
+func (s *PostgresStorage) DeleteUser(ctx context.Context, userID string) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM withdrawals WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM orders WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, "DELETE FROM users WHERE id = $1", userID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+// withTx runs fn in a transaction on the primary and commits it if fn returns nil; otherwise,
+// and on a panic, the transaction is rolled back. The caller always gets the error of fn or of
+// the commit; a failed rollback is only logged.
+func (s *PostgresStorage) withTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	return runTx(ctx, s.pool.Begin, s.log, fn)
+}
+
+func runTx(ctx context.Context, begin func(context.Context) (pgx.Tx, error), log logger.Logger, fn func(tx pgx.Tx) error) error {
+	tx, err := begin(ctx)
+	if err != nil {
+		return err
+	}
+	committing := false
+	defer func() {
+		// A failed commit has already ended the transaction, so there is nothing to roll back.
+		if committing {
+			return
+		}
+		// With ctx done pgx closes the connection instead, which discards the transaction too.
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) && ctx.Err() == nil {
+			log.Errorf("failed to rollback transaction: %v", err)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	committing = true
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// CreateOrder uploads an order. With a positive maxOrders, a user who already has that many
+// orders gets ErrOrderQuotaExceeded, unless the number is one that already exists.
+func (s *PostgresStorage) CreateOrder(ctx context.Context, userID, orderNumber string, maxOrders int) error {
+	if maxOrders > 0 {
+		return s.createOrderWithQuota(ctx, userID, orderNumber, maxOrders)
+	}
+
+	_, err := s.pool.Exec(ctx, "INSERT INTO orders (id, user_id, number, status, uploaded_at, updated_at) VALUES ($1, $2, $3, $4, $5, $5)",
+		uuid.NewString(), userID, orderNumber, models.OrderStatusNew, time.Now())
+	if err != nil {
+		if isUniqueViolation(err, constraintOrderNumber) {
 			existingOrder, getErr := s.GetOrderByNumber(ctx, orderNumber)
 			if getErr != nil {
 				return getErr
@@ -117,28 +430,244 @@ func (s *PostgresStorage) CreateOrder(ctx context.Context, userID, orderNumber s
 			if existingOrder.UserID == userID {
 				return ErrOrderExists
 			}
-			return ErrOrderExistsOther
+			return &OrderOwnerError{OwnerID: existingOrder.UserID}
 		}
 		return err
 	}
 	return nil
 }
 
+func (s *PostgresStorage) createOrderWithQuota(ctx context.Context, userID, orderNumber string, maxOrders int) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		count, err := lockAndCountOrders(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if count >= maxOrders {
+			if err := existingOrderError(ctx, tx, userID, orderNumber); err != nil {
+				return err
+			}
+			return ErrOrderQuotaExceeded
+		}
+
+		tag, err := tx.Exec(ctx, "INSERT INTO orders (id, user_id, number, status, uploaded_at, updated_at) VALUES ($1, $2, $3, $4, $5, $5) ON CONFLICT (number) DO NOTHING",
+			uuid.NewString(), userID, orderNumber, models.OrderStatusNew, time.Now())
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return existingOrderError(ctx, tx, userID, orderNumber)
+		}
+		return nil
+	})
+}
+
+// lockAndCountOrders locks the user's row for the rest of the transaction, so that concurrent
+// uploads cannot both pass the quota check, and returns the user's order count.
+func lockAndCountOrders(ctx context.Context, tx pgx.Tx, userID string) (int, error) {
+	var locked string
+	if err := tx.QueryRow(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&locked); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+		return 0, err
+	}
+	return countOrdersByUser(ctx, tx, userID)
+}
+
+// CountOrdersByUser counts the user's orders, the number MaxOrdersPerUser is checked against.
+func (s *PostgresStorage) CountOrdersByUser(ctx context.Context, userID string) (int, error) {
+	return countOrdersByUser(ctx, s.replica, userID)
+}
+
+func countOrdersByUser(ctx context.Context, q rowQuerier, userID string) (int, error) {
+	var count int
+	err := q.QueryRow(ctx, "SELECT COUNT(*) FROM orders WHERE user_id = $1", userID).Scan(&count)
+	return count, err
+}
+
+// existingOrderError reports ErrOrderExists or an OrderOwnerError for an uploaded number and nil otherwise.
+func existingOrderError(ctx context.Context, q rowQuerier, userID, orderNumber string) error {
+	var ownerID string
+	err := q.QueryRow(ctx, "SELECT user_id FROM orders WHERE number = $1", orderNumber).Scan(&ownerID)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	case ownerID == userID:
+		return ErrOrderExists
+	default:
+		return &OrderOwnerError{OwnerID: ownerID}
+	}
+}
+
+// CreateOrdersBatch inserts the orders in one transaction and reports, in input order,
+// whether each one was accepted or already uploaded by this or another user. With a positive
+// maxOrders, new numbers beyond the user's quota are reported as quota_exceeded.
+func (s *PostgresStorage) CreateOrdersBatch(ctx context.Context, userID string, orderNumbers []string, maxOrders int) ([]models.BatchOrderResult, error) {
+	results := make([]models.BatchOrderResult, 0, len(orderNumbers))
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var count int
+		if maxOrders > 0 {
+			var err error
+			if count, err = lockAndCountOrders(ctx, tx, userID); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		for _, number := range orderNumbers {
+			if maxOrders > 0 && count >= maxOrders {
+				result := models.BatchOrderResult{Order: number, Result: models.BatchOrderQuotaExceeded}
+				var ownerErr *OrderOwnerError
+				switch err := existingOrderError(ctx, tx, userID, number); {
+				case errors.Is(err, ErrOrderExists):
+					result.Result = models.BatchOrderDuplicate
+				case errors.As(err, &ownerErr):
+					result.Result, result.OwnerID = models.BatchOrderOwnedByOther, ownerErr.OwnerID
+				case err != nil:
+					return err
+				}
+				results = append(results, result)
+				continue
+			}
+
+			tag, err := tx.Exec(ctx, "INSERT INTO orders (id, user_id, number, status, uploaded_at, updated_at) VALUES ($1, $2, $3, $4, $5, $5) ON CONFLICT (number) DO NOTHING",
+				uuid.NewString(), userID, number, models.OrderStatusNew, now)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() > 0 {
+				count++
+				results = append(results, models.BatchOrderResult{Order: number, Result: models.BatchOrderAccepted})
+				continue
+			}
+
+			var ownerID string
+			if err := tx.QueryRow(ctx, "SELECT user_id FROM orders WHERE number = $1", number).Scan(&ownerID); err != nil {
+				return err
+			}
+			result := models.BatchOrderResult{Order: number, Result: models.BatchOrderOwnedByOther, OwnerID: ownerID}
+			if ownerID == userID {
+				result = models.BatchOrderResult{Order: number, Result: models.BatchOrderDuplicate}
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetOrderByNumber returns the order with the given number, whoever owns it, or ErrOrderNotFound.
 func (s *PostgresStorage) GetOrderByNumber(ctx context.Context, orderNumber string) (*models.Order, error) {
 	order := &models.Order{}
-	err := s.pool.QueryRow(ctx, "SELECT user_id, number, status, accrual, uploaded_at FROM orders WHERE number = $1", orderNumber).
-		Scan(&order.UserID, &order.Number, &order.Status, &order.Accrual, &order.UploadedAt)
+	err := s.pool.QueryRow(ctx, "SELECT user_id, number, status, accrual, uploaded_at, updated_at FROM orders WHERE number = $1", orderNumber).
+		Scan(&order.UserID, &order.Number, &order.Status, &order.Accrual, &order.UploadedAt, &order.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, pgx.ErrNoRows
+			return nil, ErrOrderNotFound
 		}
 		return nil, err
 	}
 	return order, nil
 }
 
-func (s *PostgresStorage) GetOrdersByUser(ctx context.Context, userID string) ([]models.Order, error) {
-	rows, err := s.pool.Query(ctx, "SELECT number, status, accrual, uploaded_at FROM orders WHERE user_id = $1 ORDER BY uploaded_at DESC", userID)
+// GetUserOrder returns the user's order with the given number, or ErrOrderNotFound if it is missing or owned by someone else.
+func (s *PostgresStorage) GetUserOrder(ctx context.Context, userID, orderNumber string) (*models.Order, error) {
+	order := &models.Order{}
+	err := s.pool.QueryRow(ctx, "SELECT user_id, number, status, accrual, uploaded_at, updated_at FROM orders WHERE user_id = $1 AND number = $2", userID, orderNumber).
+		Scan(&order.UserID, &order.Number, &order.Status, &order.Accrual, &order.UploadedAt, &order.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	return order, nil
+}
+
+// DeleteOrder removes a user's order as long as accrual has not reached a terminal status for it.
+func (s *PostgresStorage) DeleteOrder(ctx context.Context, userID, orderNumber string) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM orders WHERE user_id = $1 AND number = $2 AND status NOT IN ('PROCESSED', 'INVALID')", userID, orderNumber)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	var exists bool
+	err = s.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM orders WHERE user_id = $1 AND number = $2)", userID, orderNumber).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrOrderNotPending
+	}
+	return ErrOrderNotFound
+}
+
+// RefreshOrder makes a user's pending order due for the next accrual poll and resets its poll
+// attempts, so it is not slowed down either.
+func (s *PostgresStorage) RefreshOrder(ctx context.Context, userID, orderNumber string) error {
+	tag, err := s.pool.Exec(ctx, "UPDATE orders SET attempts = 0, last_checked_at = NULL WHERE user_id = $1 AND number = $2 AND status NOT IN ('PROCESSED', 'INVALID')", userID, orderNumber)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	var exists bool
+	err = s.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM orders WHERE user_id = $1 AND number = $2)", userID, orderNumber).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrOrderNotPending
+	}
+	return ErrOrderNotFound
+}
+
+func (s *PostgresStorage) GetOrdersByUser(ctx context.Context, userID string, filter models.OrderFilter) ([]models.Order, error) {
+	sortBy := filter.SortBy
+	if sortBy == "" {
+		sortBy = models.OrderSortUploadedAt
+	}
+	// The sort column is interpolated into SQL, so only whitelisted names are accepted.
+	column, ok := orderSortColumns[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort field %q", sortBy)
+	}
+	direction := "DESC"
+	if filter.Ascending {
+		direction = "ASC"
+	}
+
+	query := "SELECT number, status, accrual, uploaded_at, updated_at FROM orders WHERE user_id = $1"
+	args := []any{userID}
+	if filter.After != nil {
+		if sortBy != models.OrderSortUploadedAt || filter.Ascending {
+			return nil, errors.New("cursor pagination requires the default sort")
+		}
+		args = append(args, filter.After.UploadedAt, filter.After.Number)
+		query += fmt.Sprintf(" AND (uploaded_at, number) < ($%d, $%d)", len(args)-1, len(args))
+	}
+	// number breaks ties so that the default order matches the (uploaded_at, number) keyset.
+	query += fmt.Sprintf(" ORDER BY %s %s NULLS LAST, uploaded_at DESC, number DESC", column, direction)
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	rows, err := s.replica.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -147,7 +676,7 @@ func (s *PostgresStorage) GetOrdersByUser(ctx context.Context, userID string) ([
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
-		if err := rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt); err != nil {
+		if err := rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt, &order.UpdatedAt); err != nil {
 			return nil, err
 		}
 		orders = append(orders, order)
@@ -155,9 +684,11 @@ func (s *PostgresStorage) GetOrdersByUser(ctx context.Context, userID string) ([
 	return orders, nil
 }
 
-func (s *PostgresStorage) GetOrdersByStatus(ctx context.Context, statuses []string) ([]models.Order, error) {
-	query := "SELECT number, status, accrual, uploaded_at FROM orders WHERE status = ANY($1)"
-	rows, err := s.pool.Query(ctx, query, statuses)
+// GetOrdersUpdatedSince returns the user's orders created or changed after since, oldest change first.
+func (s *PostgresStorage) GetOrdersUpdatedSince(ctx context.Context, userID string, since time.Time) ([]models.Order, error) {
+	query := `SELECT number, status, accrual, uploaded_at, updated_at FROM orders
+		WHERE user_id = $1 AND updated_at > $2 ORDER BY updated_at, number`
+	rows, err := s.replica.Query(ctx, query, userID, since)
 	if err != nil {
 		return nil, err
 	}
@@ -166,7 +697,7 @@ func (s *PostgresStorage) GetOrdersByStatus(ctx context.Context, statuses []stri
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
-		if err := rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt); err != nil {
+		if err := rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt, &order.UpdatedAt); err != nil {
 			return nil, err
 		}
 		orders = append(orders, order)
@@ -174,65 +705,275 @@ func (s *PostgresStorage) GetOrdersByStatus(ctx context.Context, statuses []stri
 	return orders, nil
 }
 
-func (s *PostgresStorage) UpdateOrder(ctx context.Context, orderNumber, status string, accrual *float64) error {
-	_, err := s.pool.Exec(ctx, "UPDATE orders SET status = $1, accrual = $2 WHERE number = $3", status, accrual, orderNumber)
-	return err
+// GetOrdersVersion counts the user's orders and finds the latest upload or accrual change among them.
+func (s *PostgresStorage) GetOrdersVersion(ctx context.Context, userID string) (*models.ListVersion, error) {
+	version := &models.ListVersion{}
+	err := s.replica.QueryRow(ctx, "SELECT COUNT(*), COALESCE(MAX(updated_at), to_timestamp(0)) FROM orders WHERE user_id = $1", userID).
+		Scan(&version.Count, &version.LastModified)
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
 }
 
-func (s *PostgresStorage) GetBalance(ctx context.Context, userID string) (*models.Balance, error) {
-	balance := &models.Balance{}
+// GetOrdersByStatus returns orders in the given statuses that were never polled or last polled before checkedBefore.
+func (s *PostgresStorage) GetOrdersByStatus(ctx context.Context, statuses []models.OrderStatus, checkedBefore time.Time) ([]models.Order, error) {
+	names := make([]string, len(statuses))
+	for i, status := range statuses {
+		names[i] = string(status)
+	}
 
-	err := s.pool.QueryRow(ctx, "SELECT COALESCE(SUM(accrual), 0) FROM orders WHERE user_id = $1 AND status = 'PROCESSED'", userID).Scan(&balance.Current)
+	query := `SELECT number, status, accrual, uploaded_at, attempts FROM orders
+		WHERE status = ANY($1) AND (last_checked_at IS NULL OR last_checked_at < $2)`
+	rows, err := s.pool.Query(ctx, query, names, checkedBefore)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	var orders []models.Order
+	for rows.Next() {
+		var order models.Order
+		if err := rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt, &order.Attempts); err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
 
-	err = s.pool.QueryRow(ctx, "SELECT COALESCE(SUM(sum), 0) FROM withdrawals WHERE user_id = $1", userID).Scan(&balance.Withdrawn)
+func (s *PostgresStorage) UpdateOrder(ctx context.Context, orderNumber string, status models.OrderStatus, accrual *float64) error {
+	_, err := s.pool.Exec(ctx, updateOrderQuery, status, accrual, orderNumber, time.Now())
+	return err
+}
+
+// MarkOrdersPolled records a poll that did not yield a terminal status.
+func (s *PostgresStorage) MarkOrdersPolled(ctx context.Context, orderNumbers []string) error {
+	if len(orderNumbers) == 0 {
+		return nil
+	}
+	_, err := s.pool.Exec(ctx, "UPDATE orders SET attempts = attempts + 1, last_checked_at = $1 WHERE number = ANY($2)", time.Now(), orderNumbers)
+	return err
+}
+
+func (s *PostgresStorage) InvalidateStaleOrders(ctx context.Context, statuses []models.OrderStatus, uploadedBefore time.Time) (int64, error) {
+	names := make([]string, len(statuses))
+	for i, status := range statuses {
+		names[i] = string(status)
+	}
+
+	tag, err := s.pool.Exec(ctx, "UPDATE orders SET status = $1, updated_at = $4 WHERE status = ANY($2) AND uploaded_at < $3",
+		models.OrderStatusInvalid, names, uploadedBefore, time.Now())
 	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetOldestUploadedAt returns the upload time of the oldest order in one of the statuses, or nil if there is none.
+func (s *PostgresStorage) GetOldestUploadedAt(ctx context.Context, statuses []models.OrderStatus) (*time.Time, error) {
+	names := make([]string, len(statuses))
+	for i, status := range statuses {
+		names[i] = string(status)
+	}
+
+	var oldest *time.Time
+	if err := s.pool.QueryRow(ctx, "SELECT MIN(uploaded_at) FROM orders WHERE status = ANY($1)", names).Scan(&oldest); err != nil {
 		return nil, err
 	}
+	return oldest, nil
+}
 
-	balance.Current -= balance.Withdrawn
+// UpdateOrdersBatch applies the updates in one round trip and returns the orders that actually changed.
+func (s *PostgresStorage) UpdateOrdersBatch(ctx context.Context, updates []models.OrderUpdate) ([]models.Order, error) {
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	batch := &pgx.Batch{}
+	now := time.Now()
+	for _, u := range updates {
+		batch.Queue(updateOrderQuery, u.Status, u.Accrual, u.Number, now)
+	}
+	results := s.pool.SendBatch(ctx, batch)
+	defer results.Close()
+
+	var updated []models.Order
+	for range updates {
+		var order models.Order
+		err := results.QueryRow().Scan(&order.UserID, &order.Number, &order.Status, &order.Accrual, &order.UploadedAt, &order.UpdatedAt)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			return updated, err
+		}
+		updated = append(updated, order)
+	}
+	return updated, results.Close()
+}
+
+func (s *PostgresStorage) GetBalance(ctx context.Context, userID string) (*models.Balance, error) {
+	return queryBalance(ctx, s.replica, userID)
+}
+
+// rowQuerier is satisfied by both the pools and a transaction.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// queryBalance reads the maintained balance; users without a row have never earned or spent points.
+func queryBalance(ctx context.Context, q rowQuerier, userID string) (*models.Balance, error) {
+	balance := &models.Balance{}
+
+	err := q.QueryRow(ctx, "SELECT current, withdrawn FROM balances WHERE user_id = $1", userID).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
 
 	return balance, nil
 }
 
-func (s *PostgresStorage) CreateWithdrawal(ctx context.Context, userID, orderNumber string, sum float64) error {
-	tx, err := s.pool.Begin(ctx)
+func (s *PostgresStorage) GetOrderStats(ctx context.Context, userID string) (*models.OrderStats, error) {
+	rows, err := s.replica.Query(ctx, `
+		SELECT status, COUNT(*), COALESCE(SUM(accrual), 0)
+		FROM orders
+		WHERE user_id = $1
+		GROUP BY status
+	`, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer func() {
-		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
-			s.log.Errorf("failed to rollback transaction: %v", err)
+	defer rows.Close()
+
+	stats := &models.OrderStats{}
+	for rows.Next() {
+		var (
+			status  models.OrderStatus
+			count   int
+			accrual float64
+		)
+		if err := rows.Scan(&status, &count, &accrual); err != nil {
+			return nil, err
 		}
-	}()
-	var balance models.Balance
-	err = tx.QueryRow(ctx, "SELECT COALESCE(SUM(accrual), 0) FROM orders WHERE user_id = $1 AND status = 'PROCESSED'", userID).Scan(&balance.Current)
+		switch status {
+		case models.OrderStatusNew:
+			stats.New = count
+		case models.OrderStatusProcessing:
+			stats.Processing = count
+		case models.OrderStatusProcessed:
+			stats.Processed = count
+			stats.TotalAccrued = accrual
+		case models.OrderStatusInvalid:
+			stats.Invalid = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (s *PostgresStorage) GetBalanceSummary(ctx context.Context, userID string) (*models.BalanceSummary, error) {
+	summary := &models.BalanceSummary{}
+
+	err := s.replica.QueryRow(ctx, `
+		SELECT
+			COALESCE((SELECT current FROM balances WHERE user_id = $1), 0),
+			COALESCE((SELECT withdrawn FROM balances WHERE user_id = $1), 0),
+			(SELECT COUNT(*) FROM orders WHERE user_id = $1),
+			(SELECT COUNT(*) FROM withdrawals WHERE user_id = $1)
+	`, userID).Scan(&summary.Current, &summary.Withdrawn, &summary.OrderCount, &summary.WithdrawalCount)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = tx.QueryRow(ctx, "SELECT COALESCE(SUM(sum), 0) FROM withdrawals WHERE user_id = $1", userID).Scan(&balance.Withdrawn)
+	summary.TotalAccrued = summary.Current + summary.Withdrawn
+
+	return summary, nil
+}
+
+// checkFunds returns the balance that withdrawing sum would leave, or ErrInsufficientFunds.
+func checkFunds(ctx context.Context, q rowQuerier, userID string, sum float64) (*models.Balance, error) {
+	balance, err := queryBalance(ctx, q, userID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if balance.Current < sum {
+		return nil, ErrInsufficientFunds
+	}
+
+	balance.Current -= sum
+	balance.Withdrawn += sum
+	return balance, nil
+}
+
+// CheckWithdrawal runs the checks of CreateWithdrawal without withdrawing anything and
+// returns the balance the withdrawal would leave.
+func (s *PostgresStorage) CheckWithdrawal(ctx context.Context, userID string, sum float64) (*models.Balance, error) {
+	if sum <= 0 || math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return nil, ErrInvalidSum
 	}
+	return checkFunds(ctx, s.pool, userID, sum)
+}
 
-	if (balance.Current - balance.Withdrawn) < sum {
-		return ErrInsufficientFunds
+// CreateWithdrawal withdraws sum and returns the resulting balance. The balance row is debited
+// with a conditional UPDATE, so concurrent withdrawals can never overdraw it.
+func (s *PostgresStorage) CreateWithdrawal(ctx context.Context, userID, orderNumber string, sum float64) (*models.Balance, error) {
+	if sum <= 0 || math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return nil, ErrInvalidSum
 	}
 
-	_, err = tx.Exec(ctx, "INSERT INTO withdrawals (id, user_id, order_number, sum, processed_at) VALUES ($1, $2, $3, $4, $5)",
-		uuid.NewString(), userID, orderNumber, sum, time.Now())
-	if err != nil {
+	balance := &models.Balance{}
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `UPDATE balances SET current = current - $2, withdrawn = withdrawn + $2
+			WHERE user_id = $1 AND current >= $2 RETURNING current, withdrawn`, userID, sum).Scan(&balance.Current, &balance.Withdrawn)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrInsufficientFunds
+			}
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "INSERT INTO withdrawals (id, user_id, order_number, sum, processed_at) VALUES ($1, $2, $3, $4, $5)",
+			uuid.NewString(), userID, orderNumber, sum, time.Now())
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return balance, nil
+}
 
-	return tx.Commit(ctx)
+// withdrawalConditions builds the WHERE clause shared by listing and counting withdrawals.
+func withdrawalConditions(userID string, filter models.WithdrawalFilter) (string, []any) {
+	where := "user_id = $1"
+	args := []any{userID}
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		where += fmt.Sprintf(" AND processed_at >= $%d", len(args))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		where += fmt.Sprintf(" AND processed_at < $%d", len(args))
+	}
+	return where, args
 }
 
-func (s *PostgresStorage) GetWithdrawalsByUser(ctx context.Context, userID string) ([]models.Withdrawal, error) {
-	rows, err := s.pool.Query(ctx, "SELECT order_number, sum, processed_at FROM withdrawals WHERE user_id = $1 ORDER BY processed_at DESC", userID)
+func (s *PostgresStorage) GetWithdrawalsByUser(ctx context.Context, userID string, filter models.WithdrawalFilter) ([]models.Withdrawal, error) {
+	where, args := withdrawalConditions(userID, filter)
+	query := "SELECT order_number, sum, processed_at FROM withdrawals WHERE " + where + " ORDER BY processed_at DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := s.replica.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -247,3 +988,68 @@ func (s *PostgresStorage) GetWithdrawalsByUser(ctx context.Context, userID strin
 	}
 	return withdrawals, nil
 }
+
+// GetWithdrawalsVersion describes the withdrawals matching filter's time range; Limit and Offset are ignored.
+// Withdrawals never change, so the newest one is the last modification.
+func (s *PostgresStorage) GetWithdrawalsVersion(ctx context.Context, userID string, filter models.WithdrawalFilter) (*models.ListVersion, error) {
+	where, args := withdrawalConditions(userID, filter)
+	version := &models.ListVersion{}
+	err := s.replica.QueryRow(ctx, "SELECT COUNT(*), COALESCE(MAX(processed_at), to_timestamp(0)) FROM withdrawals WHERE "+where, args...).
+		Scan(&version.Count, &version.LastModified)
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// CountWithdrawalsByUser counts the withdrawals matching filter's time range; Limit and Offset are ignored.
+func (s *PostgresStorage) CountWithdrawalsByUser(ctx context.Context, userID string, filter models.WithdrawalFilter) (int, error) {
+	where, args := withdrawalConditions(userID, filter)
+	var count int
+	err := s.replica.QueryRow(ctx, "SELECT COUNT(*) FROM withdrawals WHERE "+where, args...).Scan(&count)
+	return count, err
+}
+
+// ledgerQuery lists the user's balance changes: processed orders count as accrued when accrual
+// last changed them, withdrawals when they were made.
+const ledgerQuery = `
+	SELECT 'accrual' AS type, accrual AS amount, number AS order_number, updated_at AS at
+		FROM orders WHERE user_id = $1 AND status = 'PROCESSED' AND accrual IS NOT NULL
+	UNION ALL
+	SELECT 'withdrawal', -sum, order_number, processed_at
+		FROM withdrawals WHERE user_id = $1`
+
+// GetLedgerByUser returns the user's accruals and withdrawals merged, newest first; zero limit means no limit.
+func (s *PostgresStorage) GetLedgerByUser(ctx context.Context, userID string, limit, offset int) ([]models.LedgerEntry, error) {
+	query := "SELECT type, amount, order_number, at FROM (" + ledgerQuery + ") AS ledger ORDER BY at DESC, order_number DESC"
+	args := []any{userID}
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if offset > 0 {
+		args = append(args, offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := s.replica.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []models.LedgerEntry
+	for rows.Next() {
+		var e models.LedgerEntry
+		if err := rows.Scan(&e.Type, &e.Amount, &e.Order, &e.At); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s *PostgresStorage) CountLedgerByUser(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := s.replica.QueryRow(ctx, "SELECT COUNT(*) FROM ("+ledgerQuery+") AS ledger", userID).Scan(&count)
+	return count, err
+}
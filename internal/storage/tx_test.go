@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// fakeTx records how a transaction was ended; other pgx.Tx methods are not used by runTx.
+type fakeTx struct {
+	pgx.Tx
+	commitErr  error
+	committed  bool
+	rolledBack bool
+}
+
+func (tx *fakeTx) Commit(context.Context) error {
+	tx.committed = true
+	return tx.commitErr
+}
+
+func (tx *fakeTx) Rollback(context.Context) error {
+	tx.rolledBack = true
+	if tx.committed {
+		return pgx.ErrTxClosed
+	}
+	return nil
+}
+
+func beginFake(tx *fakeTx) func(context.Context) (pgx.Tx, error) {
+	return func(context.Context) (pgx.Tx, error) { return tx, nil }
+}
+
+func TestRunTxCommitFailure(t *testing.T) {
+	log, hook := test.NewNullLogger()
+	commitErr := errors.New("connection reset")
+	tx := &fakeTx{commitErr: commitErr}
+
+	err := runTx(context.Background(), beginFake(tx), log, func(pgx.Tx) error { return nil })
+
+	if !errors.Is(err, commitErr) {
+		t.Fatalf("err = %v, want it to wrap %v", err, commitErr)
+	}
+	if tx.rolledBack {
+		t.Error("transaction was rolled back after a failed commit")
+	}
+	for _, entry := range hook.AllEntries() {
+		if entry.Level <= logrus.ErrorLevel {
+			t.Errorf("unexpected log entry: %s", entry.Message)
+		}
+	}
+}
+
+func TestRunTxRollsBackOnError(t *testing.T) {
+	log, hook := test.NewNullLogger()
+	fnErr := errors.New("insufficient funds")
+	tx := &fakeTx{}
+
+	err := runTx(context.Background(), beginFake(tx), log, func(pgx.Tx) error { return fnErr })
+
+	if err != fnErr {
+		t.Fatalf("err = %v, want %v", err, fnErr)
+	}
+	if tx.committed || !tx.rolledBack {
+		t.Errorf("committed = %t, rolled back = %t; want a rollback only", tx.committed, tx.rolledBack)
+	}
+	if len(hook.AllEntries()) != 0 {
+		t.Errorf("unexpected log entries: %d", len(hook.AllEntries()))
+	}
+}
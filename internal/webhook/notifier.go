@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/MarkMiraclee/gophermart/internal/logger"
+	"github.com/MarkMiraclee/gophermart/internal/models"
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	SignatureHeader = "X-Gophermart-Signature"
+
+	queueSize        = 1024
+	requestTimeout   = 5 * time.Second
+	retryCount       = 5
+	retryWaitTime    = 500 * time.Millisecond
+	retryMaxWaitTime = 30 * time.Second
+)
+
+// Notifier delivers order events to an outbound webhook in the background,
+// so slow or failing receivers never block the caller.
+type Notifier struct {
+	url    string
+	secret string
+	log    logger.Logger
+	client *resty.Client
+	queue  chan models.OrderEvent
+}
+
+func NewNotifier(url, secret string, log logger.Logger) *Notifier {
+	return &Notifier{
+		url:    url,
+		secret: secret,
+		log:    log,
+		client: resty.New().
+			SetTimeout(requestTimeout).
+			SetRetryCount(retryCount).
+			SetRetryWaitTime(retryWaitTime).
+			SetRetryMaxWaitTime(retryMaxWaitTime).
+			AddRetryCondition(func(resp *resty.Response, err error) bool {
+				if err != nil {
+					return true
+				}
+				return resp.StatusCode() >= http.StatusInternalServerError || resp.StatusCode() == http.StatusTooManyRequests
+			}),
+		queue: make(chan models.OrderEvent, queueSize),
+	}
+}
+
+func (n *Notifier) Start(ctx context.Context) {
+	n.log.Info("webhook notifier started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			n.log.Info("webhook notifier stopped")
+			return
+		case event := <-n.queue:
+			n.deliver(ctx, event)
+		}
+	}
+}
+
+// Notify enqueues an event for delivery. Events are dropped when the queue is full.
+func (n *Notifier) Notify(order models.Order) {
+	event := models.OrderEvent{
+		Order:   order.Number,
+		Status:  order.Status,
+		Accrual: order.Accrual,
+		UserID:  order.UserID,
+	}
+
+	select {
+	case n.queue <- event:
+	default:
+		n.log.Errorf("webhook queue is full, dropping event for order %s", event.Order)
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, event models.OrderEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		n.log.Errorf("failed to marshal webhook event for order %s: %v", event.Order, err)
+		return
+	}
+
+	req := n.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body)
+	if n.secret != "" {
+		req.SetHeader(SignatureHeader, "sha256="+sign(body, n.secret))
+	}
+
+	resp, err := req.Post(n.url)
+	if err != nil {
+		n.log.Errorf("failed to deliver webhook for order %s: %v", event.Order, err)
+		return
+	}
+	if resp.IsError() {
+		n.log.Errorf("webhook for order %s rejected with status %d", event.Order, resp.StatusCode())
+	}
+}
+
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}